@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"dyzs/galaxy/model"
+	"sort"
+)
+
+//上报目录与已保存目录的差异
+type Diff struct {
+	Added   []*model.Channel
+	Changed []*model.Channel
+	Removed []*model.Channel //仅收齐完整目录时计算
+}
+
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+//保存设备上报的目录并返回与已保存目录的差异，差异的计算和应用在同一把写锁内完成；
+//状态和上报时间不算作变化（状态变化单独上报）；
+//received不为nil时表示设备完整目录的通道编号，已保存但不在其中的通道计为删除并删除
+func (r *Registry) Sync(fromId string, channels []*model.Channel, received map[string]bool) *Diff {
+	r.write.Lock()
+	r.RLock()
+	d := r.diff(fromId, channels, received)
+	r.RUnlock()
+	changes, listeners := r.putLocked(channels)
+	if len(d.Removed) > 0 {
+		ids := make([]string, 0, len(d.Removed))
+		for _, c := range d.Removed {
+			ids = append(ids, c.DeviceID)
+		}
+		r.removeLocked(ids)
+	}
+	r.write.Unlock()
+	r.notify(changes, listeners)
+	return d
+}
+
+//调用方持有读锁
+func (r *Registry) diff(fromId string, channels []*model.Channel, received map[string]bool) *Diff {
+	d := &Diff{}
+	for _, c := range channels {
+		old, ok := r.channels[c.DeviceID]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, c)
+		case changed(old, c):
+			d.Changed = append(d.Changed, c)
+		}
+	}
+	if received != nil {
+		for id := range r.byDevice[fromId] {
+			if !received[id] {
+				d.Removed = append(d.Removed, r.channels[id])
+			}
+		}
+		sort.Slice(d.Removed, func(i, j int) bool {
+			return d.Removed[i].DeviceID < d.Removed[j].DeviceID
+		})
+	}
+	return d
+}
+
+//已保存目录的设备
+func (r *Registry) Devices() []string {
+	r.RLock()
+	defer r.RUnlock()
+	res := make([]string, 0, len(r.byDevice))
+	for fromId := range r.byDevice {
+		res = append(res, fromId)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func changed(old, c *model.Channel) bool {
+	o, n := *old, *c
+	o.Status, n.Status = "", ""
+	o.LastSeen, n.LastSeen = 0, 0
+	return o != n
+}
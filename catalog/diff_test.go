@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"dyzs/galaxy/model"
+	"reflect"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	r := NewRegistry(NewStore())
+	other := newChannel("Q", "34020000001320000009", "其他设备", "", "")
+	r.Put(other)
+
+	c1 := newChannel("P", "34020000001320000001", "东门", "", "")
+	c2 := newChannel("P", "34020000001320000002", "西门", "", "")
+	c3 := newChannel("P", "34020000001320000003", "南门", "", "")
+	renamed := *c2
+	renamed.Name = "西门2"
+	offline := *c1
+	offline.Status = "OFF"
+
+	steps := []struct {
+		name     string
+		channels []*model.Channel
+		received map[string]bool
+		added    []string
+		changed  []string
+		removed  []string
+		saved    []string
+	}{
+		{
+			name:     "首次上报",
+			channels: []*model.Channel{c1, c2},
+			added:    []string{c1.DeviceID, c2.DeviceID},
+			saved:    []string{c1.DeviceID, c2.DeviceID},
+		},
+		{
+			name:     "未收齐时不计算删除",
+			channels: []*model.Channel{c3},
+			added:    []string{c3.DeviceID},
+			saved:    []string{c1.DeviceID, c2.DeviceID, c3.DeviceID},
+		},
+		{
+			name:     "状态变化不算作变更",
+			channels: []*model.Channel{&offline, &renamed},
+			changed:  []string{c2.DeviceID},
+			saved:    []string{c1.DeviceID, c2.DeviceID, c3.DeviceID},
+		},
+		{
+			name:     "收齐后删除未上报的通道",
+			channels: []*model.Channel{c1},
+			received: map[string]bool{c1.DeviceID: true},
+			removed:  []string{c2.DeviceID, c3.DeviceID},
+			saved:    []string{c1.DeviceID},
+		},
+		{
+			name:     "删除后重新上报计为新增",
+			channels: []*model.Channel{c1, c3},
+			received: map[string]bool{c1.DeviceID: true, c3.DeviceID: true},
+			added:    []string{c3.DeviceID},
+			saved:    []string{c1.DeviceID, c3.DeviceID},
+		},
+	}
+	for _, s := range steps {
+		d := r.Sync("P", s.channels, s.received)
+		if !reflect.DeepEqual(ids(d.Added), s.added) && len(d.Added)+len(s.added) > 0 {
+			t.Errorf("%s：新增%v，期望%v", s.name, ids(d.Added), s.added)
+		}
+		if !reflect.DeepEqual(ids(d.Changed), s.changed) && len(d.Changed)+len(s.changed) > 0 {
+			t.Errorf("%s：变更%v，期望%v", s.name, ids(d.Changed), s.changed)
+		}
+		if !reflect.DeepEqual(ids(d.Removed), s.removed) && len(d.Removed)+len(s.removed) > 0 {
+			t.Errorf("%s：删除%v，期望%v", s.name, ids(d.Removed), s.removed)
+		}
+		saved := ids(r.Query(&Query{FromID: "P"}).ChannelList)
+		if !reflect.DeepEqual(saved, s.saved) {
+			t.Errorf("%s：保存的通道%v，期望%v", s.name, saved, s.saved)
+		}
+	}
+	if _, ok := r.Get(other.DeviceID); !ok {
+		t.Error("删除了其他设备的通道")
+	}
+	if c, _ := r.Get(c1.DeviceID); c.Status != "ON" {
+		t.Errorf("通道状态未更新：%+v", c)
+	}
+}
@@ -0,0 +1,410 @@
+package catalog
+
+import (
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//通道查询条件，字段为空表示不过滤；PageSize为0时返回全部
+type Query struct {
+	FromID    string `json:"FromId"`
+	CivilCode string `json:"CivilCode"`
+	ParentID  string `json:"ParentId"`
+	Status    string `json:"Status"`
+	Category  string `json:"Category"`
+	Name      string `json:"Name"`   //名称或通道编号包含
+	PageNo    int    `json:"PageNo"` //从1开始
+	PageSize  int    `json:"PageSize"`
+	OrderBy   string `json:"OrderBy"` //DeviceId（默认）、Name、FromId、CivilCode、Status、LastSeen
+	Desc      bool   `json:"Desc"`
+}
+
+type QueryResult struct {
+	Total       int              `json:"Total"`
+	PageNo      int              `json:"PageNo"`
+	PageSize    int              `json:"PageSize"`
+	ChannelList []*model.Channel `json:"ChannelList"`
+}
+
+type index map[string]map[string]bool
+
+func (idx index) add(key, id string) {
+	if key == "" {
+		return
+	}
+	if idx[key] == nil {
+		idx[key] = make(map[string]bool)
+	}
+	idx[key][id] = true
+}
+
+func (idx index) remove(key, id string) {
+	if ids, ok := idx[key]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx, key)
+		}
+	}
+}
+
+//通道注册表：按通道编号保存，并按设备、行政区划、父节点、状态建立索引；变更同步写入Store
+type Registry struct {
+	sync.RWMutex
+	//串行化变更：先更新内存再写redis，redis不会领先于内存
+	write       sync.Mutex
+	store       *Store
+	channels    map[string]*model.Channel
+	byDevice    index
+	byCivilCode index
+	byParent    index
+	byStatus    index
+	history     map[string][]*StatusChange
+	listeners   []func(changes []*StatusChange)
+	expired     []func(channels []*model.Channel)
+}
+
+func NewRegistry(store *Store) *Registry {
+	return &Registry{
+		store:       store,
+		channels:    make(map[string]*model.Channel),
+		byDevice:    make(index),
+		byCivilCode: make(index),
+		byParent:    make(index),
+		byStatus:    make(index),
+		history:     make(map[string][]*StatusChange),
+	}
+}
+
+//加载持久化的通道，并定期删除过期通道
+func (r *Registry) Start() {
+	channels, err := r.store.Load()
+	if err != nil {
+		logger.LOG_WARN("加载设备通道异常：", err)
+	}
+	r.Lock()
+	for _, c := range channels {
+		r.put(c)
+	}
+	r.Unlock()
+	if len(channels) > 0 {
+		logger.LOG_INFO("加载设备通道：", len(channels))
+	}
+	go func() {
+		ticker := time.NewTicker(ExpireInterval())
+		defer ticker.Stop()
+		for range ticker.C {
+			r.Expire(time.Now())
+		}
+	}()
+}
+
+//保存上报的通道（保存副本，不修改传入的通道），刷新最近上报时间；已有通道状态变化时记录历史并通知监听
+func (r *Registry) Put(channels ...*model.Channel) {
+	r.write.Lock()
+	changes, listeners := r.putLocked(channels)
+	r.write.Unlock()
+	r.notify(changes, listeners)
+}
+
+//调用方持有write锁
+func (r *Registry) putLocked(channels []*model.Channel) ([]*StatusChange, []func(changes []*StatusChange)) {
+	now := time.Now().UnixNano() / 1e6
+	changes := make([]*StatusChange, 0)
+	saved := make([]*model.Channel, 0, len(channels))
+	//上级设备变化的通道，需从原设备的hash中删除
+	moved := make([]*model.Channel, 0)
+	r.Lock()
+	for _, c := range channels {
+		copied := *c
+		copied.LastSeen = now
+		old, ok := r.channels[copied.DeviceID]
+		if ok && old.FromID != copied.FromID {
+			moved = append(moved, old)
+		}
+		if ok && normalizeStatus(old.Status) != normalizeStatus(copied.Status) {
+			change := &StatusChange{
+				DeviceID: copied.DeviceID,
+				FromID:   copied.FromID,
+				Previous: old.Status,
+				Status:   copied.Status,
+				Time:     now,
+			}
+			r.record(change)
+			changes = append(changes, change)
+		}
+		r.put(&copied)
+		saved = append(saved, &copied)
+	}
+	listeners := r.listeners
+	r.Unlock()
+	if len(moved) > 0 {
+		err := r.store.Remove(moved...)
+		if err != nil {
+			logger.LOG_WARN("redis 删除原设备通道异常，", err)
+		}
+	}
+	err := r.store.Save(saved...)
+	if err != nil {
+		logger.LOG_WARN("设备通道缓存入redis异常，", err)
+	}
+	return changes, listeners
+}
+
+func (r *Registry) notify(changes []*StatusChange, listeners []func(changes []*StatusChange)) {
+	if len(changes) == 0 {
+		return
+	}
+	logger.LOG_INFO("通道状态变化：", len(changes))
+	for _, listener := range listeners {
+		listener(changes)
+	}
+}
+
+//删除通道
+func (r *Registry) Remove(ids ...string) {
+	r.write.Lock()
+	defer r.write.Unlock()
+	r.removeLocked(ids)
+}
+
+//调用方持有write锁
+func (r *Registry) removeLocked(ids []string) {
+	removed := make([]*model.Channel, 0, len(ids))
+	r.Lock()
+	for _, id := range ids {
+		if c := r.remove(id); c != nil {
+			delete(r.history, id)
+			removed = append(removed, c)
+		}
+	}
+	r.Unlock()
+	if len(removed) == 0 {
+		return
+	}
+	err := r.store.Remove(removed...)
+	if err != nil {
+		logger.LOG_WARN("redis 删除设备通道异常，", err)
+	}
+}
+
+//删除长时间未上报的通道，并通知过期监听
+func (r *Registry) Expire(now time.Time) {
+	r.write.Lock()
+	removed := make([]*model.Channel, 0)
+	r.Lock()
+	for id, c := range r.channels {
+		if Expired(c, now) {
+			delete(r.history, id)
+			removed = append(removed, r.remove(id))
+		}
+	}
+	listeners := r.expired
+	r.Unlock()
+	if len(removed) == 0 {
+		r.write.Unlock()
+		return
+	}
+	logger.LOG_INFO("删除过期设备通道：", len(removed))
+	err := r.store.Remove(removed...)
+	if err != nil {
+		logger.LOG_WARN("redis 删除过期设备通道异常，", err)
+	}
+	r.write.Unlock()
+	for _, listener := range listeners {
+		listener(removed)
+	}
+}
+
+//注册通道过期监听，在过期删除之后、锁外调用
+func (r *Registry) OnExpire(listener func(channels []*model.Channel)) {
+	r.Lock()
+	defer r.Unlock()
+	r.expired = append(r.expired, listener)
+}
+
+//按通道编号查询
+func (r *Registry) Get(id string) (*model.Channel, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	c, ok := r.channels[id]
+	return c, ok
+}
+
+//设备下的通道
+func (r *Registry) ByDevice(fromId string) []*model.Channel {
+	r.RLock()
+	defer r.RUnlock()
+	return r.collect(r.byDevice[fromId])
+}
+
+func (r *Registry) Len() int {
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.channels)
+}
+
+//条件查询，先用索引缩小范围，再过滤、排序、分页
+func (r *Registry) Query(q *Query) *QueryResult {
+	if q == nil {
+		q = &Query{}
+	}
+	r.RLock()
+	var candidates []*model.Channel
+	if ids, ok := r.candidates(q); ok {
+		candidates = r.collect(ids)
+	} else {
+		candidates = make([]*model.Channel, 0, len(r.channels))
+		for _, c := range r.channels {
+			candidates = append(candidates, c)
+		}
+	}
+	r.RUnlock()
+
+	matched := make([]*model.Channel, 0, len(candidates))
+	for _, c := range candidates {
+		if q.match(c) {
+			matched = append(matched, c)
+		}
+	}
+	sortChannels(matched, q.OrderBy, q.Desc)
+
+	res := &QueryResult{Total: len(matched), PageNo: q.PageNo, PageSize: q.PageSize}
+	if q.PageSize <= 0 {
+		res.PageNo = 1
+		res.ChannelList = matched
+		return res
+	}
+	if res.PageNo < 1 {
+		res.PageNo = 1
+	}
+	start := (res.PageNo - 1) * q.PageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + q.PageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+	res.ChannelList = matched[start:end]
+	return res
+}
+
+//索引命中的最小集合，无索引条件时返回false
+func (r *Registry) candidates(q *Query) (map[string]bool, bool) {
+	var res map[string]bool
+	found := false
+	for _, f := range []struct {
+		idx index
+		key string
+	}{
+		{r.byDevice, q.FromID},
+		{r.byCivilCode, q.CivilCode},
+		{r.byParent, q.ParentID},
+		{r.byStatus, normalizeStatus(q.Status)},
+	} {
+		if f.key == "" {
+			continue
+		}
+		ids := f.idx[f.key]
+		if !found || len(ids) < len(res) {
+			res = ids
+		}
+		found = true
+	}
+	return res, found
+}
+
+func (r *Registry) collect(ids map[string]bool) []*model.Channel {
+	res := make([]*model.Channel, 0, len(ids))
+	for id := range ids {
+		if c, ok := r.channels[id]; ok {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (r *Registry) put(c *model.Channel) {
+	r.remove(c.DeviceID)
+	r.channels[c.DeviceID] = c
+	r.byDevice.add(c.FromID, c.DeviceID)
+	r.byCivilCode.add(c.CivilCode, c.DeviceID)
+	r.byParent.add(c.ParentID, c.DeviceID)
+	r.byStatus.add(normalizeStatus(c.Status), c.DeviceID)
+}
+
+func (r *Registry) remove(id string) *model.Channel {
+	c, ok := r.channels[id]
+	if !ok {
+		return nil
+	}
+	delete(r.channels, id)
+	r.byDevice.remove(c.FromID, id)
+	r.byCivilCode.remove(c.CivilCode, id)
+	r.byParent.remove(c.ParentID, id)
+	r.byStatus.remove(normalizeStatus(c.Status), id)
+	return c
+}
+
+func (q *Query) match(c *model.Channel) bool {
+	if q.FromID != "" && c.FromID != q.FromID {
+		return false
+	}
+	if q.CivilCode != "" && c.CivilCode != q.CivilCode {
+		return false
+	}
+	if q.ParentID != "" && c.ParentID != q.ParentID {
+		return false
+	}
+	if q.Status != "" && normalizeStatus(c.Status) != normalizeStatus(q.Status) {
+		return false
+	}
+	if q.Category != "" && c.Category != q.Category {
+		return false
+	}
+	if q.Name != "" && !strings.Contains(c.Name, q.Name) && !strings.Contains(c.DeviceID, q.Name) {
+		return false
+	}
+	return true
+}
+
+//状态统一为大写，如ON、OFF
+func normalizeStatus(status string) string {
+	return strings.ToUpper(strings.TrimSpace(status))
+}
+
+func sortChannels(channels []*model.Channel, orderBy string, desc bool) {
+	var less func(a, b *model.Channel) bool
+	switch orderBy {
+	case "Name":
+		less = func(a, b *model.Channel) bool { return a.Name < b.Name }
+	case "FromId":
+		less = func(a, b *model.Channel) bool { return a.FromID < b.FromID }
+	case "CivilCode":
+		less = func(a, b *model.Channel) bool { return a.CivilCode < b.CivilCode }
+	case "Status":
+		less = func(a, b *model.Channel) bool { return normalizeStatus(a.Status) < normalizeStatus(b.Status) }
+	case "LastSeen":
+		less = func(a, b *model.Channel) bool { return a.LastSeen < b.LastSeen }
+	default:
+		less = func(a, b *model.Channel) bool { return false }
+	}
+	sort.SliceStable(channels, func(i, j int) bool {
+		a, b := channels[i], channels[j]
+		if desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		//相同时按通道编号，保证分页稳定
+		return a.DeviceID < b.DeviceID
+	})
+}
@@ -0,0 +1,70 @@
+package catalog
+
+import (
+	"dyzs/galaxy/model"
+	"reflect"
+	"testing"
+)
+
+func ids(channels []*model.Channel) []string {
+	res := make([]string, 0, len(channels))
+	for _, c := range channels {
+		res = append(res, c.DeviceID)
+	}
+	return res
+}
+
+func TestQuery(t *testing.T) {
+	r := NewRegistry(NewStore())
+	c1 := newChannel("P", "34020000001320000001", "东门", "G", "340200")
+	c2 := newChannel("P", "34020000001320000002", "西门", "G", "340200")
+	c3 := newChannel("P", "34020000001320000003", "南门", "", "340201")
+	c4 := newChannel("Q", "34020000001320000004", "北门", "", "340200")
+	c2.Status = "off"
+	c4.Status = "OFF"
+	r.Put(c1, c2, c3, c4)
+
+	cases := []struct {
+		name  string
+		query *Query
+		total int
+		want  []string
+	}{
+		{"全部", nil, 4, []string{c1.DeviceID, c2.DeviceID, c3.DeviceID, c4.DeviceID}},
+		{"按设备", &Query{FromID: "Q"}, 1, []string{c4.DeviceID}},
+		{"按行政区划", &Query{CivilCode: "340200"}, 3, []string{c1.DeviceID, c2.DeviceID, c4.DeviceID}},
+		{"按父节点", &Query{ParentID: "G"}, 2, []string{c1.DeviceID, c2.DeviceID}},
+		{"状态忽略大小写", &Query{Status: "Off"}, 2, []string{c2.DeviceID, c4.DeviceID}},
+		{"多个条件", &Query{FromID: "P", Status: "OFF"}, 1, []string{c2.DeviceID}},
+		{"名称包含", &Query{Name: "门"}, 4, []string{c1.DeviceID, c2.DeviceID, c3.DeviceID, c4.DeviceID}},
+		{"通道编号包含", &Query{Name: "0003"}, 1, []string{c3.DeviceID}},
+		{"分页", &Query{PageNo: 2, PageSize: 3}, 4, []string{c4.DeviceID}},
+		{"超出页数", &Query{PageNo: 3, PageSize: 3}, 4, []string{}},
+		{"按状态倒序", &Query{OrderBy: "Status", Desc: true}, 4, []string{c3.DeviceID, c1.DeviceID, c4.DeviceID, c2.DeviceID}},
+		{"无匹配索引", &Query{FromID: "missing"}, 0, []string{}},
+	}
+	for _, c := range cases {
+		res := r.Query(c.query)
+		if res.Total != c.total || !reflect.DeepEqual(ids(res.ChannelList), c.want) {
+			t.Errorf("%s：Total %d %v，期望 %d %v", c.name, res.Total, ids(res.ChannelList), c.total, c.want)
+		}
+	}
+}
+
+//Put保存副本，上级设备变化时更新索引
+func TestPutMovesDevice(t *testing.T) {
+	r := NewRegistry(NewStore())
+	c := newChannel("P", "34020000001320000001", "东门", "", "")
+	r.Put(c)
+	c.FromID = "Q"
+	if got := r.ByDevice("Q"); len(got) != 0 {
+		t.Fatalf("修改传入的通道影响了注册表：%v", ids(got))
+	}
+	r.Put(c)
+	if got := r.ByDevice("P"); len(got) != 0 {
+		t.Errorf("通道仍在原设备下：%v", ids(got))
+	}
+	if got := r.ByDevice("Q"); len(got) != 1 {
+		t.Errorf("通道不在新设备下：%v", ids(got))
+	}
+}
@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"github.com/spf13/viper"
+	"sort"
+)
+
+const _DEFAULT_STATUS_HISTORY = 20
+
+//通道状态变化
+type StatusChange struct {
+	DeviceID string `json:"DeviceId"`
+	FromID   string `json:"FromId"`
+	Previous string `json:"Previous"`
+	Status   string `json:"Status"`
+	Time     int64  `json:"Time"` //毫秒
+}
+
+//设备下通道的在线统计
+type StatusCount struct {
+	FromID  string `json:"FromId"`
+	Total   int    `json:"Total"`
+	Online  int    `json:"Online"`
+	Offline int    `json:"Offline"`
+	Unknown int    `json:"Unknown"` //未上报或无法识别的状态
+}
+
+//是否在线状态
+func Online(status string) bool {
+	switch normalizeStatus(status) {
+	case "ON", "ONLINE":
+		return true
+	}
+	return false
+}
+
+//是否离线状态
+func Offline(status string) bool {
+	switch normalizeStatus(status) {
+	case "OFF", "OFFLINE":
+		return true
+	}
+	return false
+}
+
+//注册状态变化监听，在Put之后、锁外调用
+func (r *Registry) OnStatusChange(listener func(changes []*StatusChange)) {
+	r.Lock()
+	defer r.Unlock()
+	r.listeners = append(r.listeners, listener)
+}
+
+//通道的状态变化历史，按时间先后，只保留最近catalog.statusHistory条，不持久化
+func (r *Registry) History(id string) []*StatusChange {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]*StatusChange{}, r.history[id]...)
+}
+
+//按设备统计在线、离线通道数，fromId为空时统计所有设备
+func (r *Registry) StatusCounts(fromId string) []*StatusCount {
+	r.RLock()
+	counts := make(map[string]*StatusCount)
+	for _, c := range r.channels {
+		if fromId != "" && c.FromID != fromId {
+			continue
+		}
+		sc := counts[c.FromID]
+		if sc == nil {
+			sc = &StatusCount{FromID: c.FromID}
+			counts[c.FromID] = sc
+		}
+		sc.Total++
+		switch {
+		case Online(c.Status):
+			sc.Online++
+		case Offline(c.Status):
+			sc.Offline++
+		default:
+			sc.Unknown++
+		}
+	}
+	r.RUnlock()
+	res := make([]*StatusCount, 0, len(counts))
+	for _, sc := range counts {
+		res = append(res, sc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].FromID < res[j].FromID
+	})
+	return res
+}
+
+func (r *Registry) record(change *StatusChange) {
+	size := viper.GetInt("catalog.statusHistory")
+	if size <= 0 {
+		size = _DEFAULT_STATUS_HISTORY
+	}
+	history := append(r.history[change.DeviceID], change)
+	if len(history) > size {
+		history = history[len(history)-size:]
+	}
+	r.history[change.DeviceID] = history
+}
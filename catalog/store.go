@@ -0,0 +1,133 @@
+//设备目录：下级上报的通道持久化在redis，重启后加载，长时间未再上报的通道过期删除
+package catalog
+
+import (
+	"dyzs/galaxy/constants"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/redis"
+	"encoding/json"
+	"github.com/spf13/viper"
+	"time"
+)
+
+const (
+	_DEFAULT_EXPIRE          = 7 * 24 * time.Hour
+	_DEFAULT_EXPIRE_INTERVAL = time.Hour
+)
+
+//通道存储：每个设备（FromID）一个hash，field为通道编号，value为通道json；
+//设备索引hash记录设备最近上报时间
+type Store struct {
+	redisClient *redis.Cache
+}
+
+func NewStore() *Store {
+	return &Store{
+		redisClient: redis.NewRedisCache(0, viper.GetString("redis.addr"), redis.FOREVER),
+	}
+}
+
+//加载所有通道
+func (s *Store) Load() ([]*model.Channel, error) {
+	devices, err := s.redisClient.HGetAll(constants.REDIS_KEY_CATALOG_DEVICES)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixNano() / 1e6
+	channels := make([]*model.Channel, 0)
+	for fromId := range devices {
+		values, err := s.redisClient.HGetAll(constants.REDIS_KEY_CATALOG_PREFIX + fromId)
+		if err != nil {
+			return nil, err
+		}
+		for deviceId, value := range values {
+			c := &model.Channel{}
+			err := json.Unmarshal(value, c)
+			if err != nil {
+				logger.LOG_WARN("通道数据解析异常：", fromId, "/", deviceId, "，", err)
+				continue
+			}
+			if c.LastSeen == 0 {
+				c.LastSeen = now
+			}
+			channels = append(channels, c)
+		}
+	}
+	return channels, nil
+}
+
+//保存通道
+func (s *Store) Save(channels ...*model.Channel) error {
+	devices := make(map[string]int64)
+	for _, c := range channels {
+		value, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		err = s.redisClient.HSet(constants.REDIS_KEY_CATALOG_PREFIX+c.FromID, c.DeviceID, value)
+		if err != nil {
+			return err
+		}
+		if c.LastSeen > devices[c.FromID] {
+			devices[c.FromID] = c.LastSeen
+		}
+	}
+	for fromId, lastSeen := range devices {
+		err := s.redisClient.HSet(constants.REDIS_KEY_CATALOG_DEVICES, fromId, lastSeen)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//删除通道，设备下无通道时同时删除设备索引
+func (s *Store) Remove(channels ...*model.Channel) error {
+	devices := make(map[string]bool)
+	for _, c := range channels {
+		_, err := s.redisClient.Hdel(constants.REDIS_KEY_CATALOG_PREFIX+c.FromID, c.DeviceID)
+		if err != nil {
+			return err
+		}
+		devices[c.FromID] = true
+	}
+	for fromId := range devices {
+		count, err := s.redisClient.HLen(constants.REDIS_KEY_CATALOG_PREFIX + fromId)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			_, err = s.redisClient.Hdel(constants.REDIS_KEY_CATALOG_DEVICES, fromId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//通道过期时间，catalog.expire，小于0不过期
+func Expire() time.Duration {
+	if d := viper.GetDuration("catalog.expire"); d != 0 {
+		return d
+	}
+	return _DEFAULT_EXPIRE
+}
+
+//过期检查间隔，catalog.expireInterval
+func ExpireInterval() time.Duration {
+	if d := viper.GetDuration("catalog.expireInterval"); d > 0 {
+		return d
+	}
+	return _DEFAULT_EXPIRE_INTERVAL
+}
+
+//是否过期
+func Expired(c *model.Channel, now time.Time) bool {
+	expire := Expire()
+	if expire < 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, c.LastSeen*1e6)) > expire
+}
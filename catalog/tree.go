@@ -0,0 +1,244 @@
+package catalog
+
+import (
+	"dyzs/galaxy/gbid"
+	"dyzs/galaxy/model"
+	"sort"
+	"strings"
+)
+
+//目录树节点类型
+const (
+	NODE_CIVIL          = "civil"         //行政区划
+	NODE_BUSINESS_GROUP = "businessGroup" //业务分组
+	NODE_VIRTUAL_ORG    = "virtualOrg"    //虚拟组织
+	NODE_DEVICE         = "device"        //设备（下级平台、NVR等）
+	NODE_CHANNEL        = "channel"       //通道
+)
+
+var nodeOrder = map[string]int{
+	NODE_CIVIL:          0,
+	NODE_BUSINESS_GROUP: 1,
+	NODE_VIRTUAL_ORG:    2,
+	NODE_DEVICE:         3,
+	NODE_CHANNEL:        4,
+}
+
+//目录树节点，Implicit表示目录中未上报、由编码推导出的节点
+type TreeNode struct {
+	ID           string      `json:"Id"`
+	Name         string      `json:"Name"`
+	Type         string      `json:"Type"`
+	TypeCode     int         `json:"TypeCode"`
+	ParentID     string      `json:"ParentId"`
+	FromID       string      `json:"FromId"`
+	Status       string      `json:"Status"`
+	Implicit     bool        `json:"Implicit"`
+	ChannelCount int         `json:"ChannelCount"` //子树中的通道数
+	Children     []*TreeNode `json:"Children,omitempty"`
+}
+
+//目录树查询条件
+type TreeQuery struct {
+	FromID string `json:"FromId"` //只包含该设备上报的目录
+	Root   string `json:"Root"`   //返回以该节点为根的子树
+	Depth  int    `json:"Depth"`  //返回的层数，0不限制
+}
+
+//构建目录树
+func (r *Registry) Tree(q *TreeQuery) []*TreeNode {
+	if q == nil {
+		q = &TreeQuery{}
+	}
+	var channels []*model.Channel
+	if q.FromID != "" {
+		channels = r.ByDevice(q.FromID)
+	} else {
+		channels = r.Query(nil).ChannelList
+	}
+	roots := BuildTree(channels)
+	if q.Root != "" {
+		node := findNode(roots, q.Root)
+		if node == nil {
+			return []*TreeNode{}
+		}
+		roots = []*TreeNode{node}
+	}
+	if q.Depth > 0 {
+		for _, n := range roots {
+			truncate(n, q.Depth)
+		}
+	}
+	return roots
+}
+
+//由目录构建组织树：行政区划按编码前缀嵌套；其他节点优先挂到ParentID（多级路径从最后一级起取目录中存在的节点），
+//其次业务分组、设备挂到所属行政区划，最后挂到来源设备，都找不到时作为根节点；ParentID成环时环上编码最小的节点作为根节点
+func BuildTree(channels []*model.Channel) []*TreeNode {
+	nodes := make(map[string]*TreeNode, len(channels))
+	civilCodes := make(map[string]bool)
+	for _, c := range channels {
+		n := &TreeNode{
+			ID:       c.DeviceID,
+			Name:     c.Name,
+			Type:     nodeType(c),
+			TypeCode: c.TypeCode,
+			FromID:   c.FromID,
+			Status:   c.Status,
+		}
+		nodes[n.ID] = n
+		if n.Type == NODE_CIVIL {
+			continue
+		}
+		if (n.Type == NODE_BUSINESS_GROUP || n.Type == NODE_DEVICE) && gbid.IsCivilCode(c.CivilCode) {
+			civilCodes[c.CivilCode] = true
+		}
+	}
+	//下级设备和分组所属行政区划未在目录中上报时补充节点
+	for code := range civilCodes {
+		if _, ok := nodes[code]; !ok {
+			nodes[code] = &TreeNode{ID: code, Name: code, Type: NODE_CIVIL, Implicit: true}
+		}
+	}
+	for _, c := range channels {
+		if _, ok := nodes[c.FromID]; !ok && c.FromID != "" {
+			nodes[c.FromID] = &TreeNode{ID: c.FromID, Name: c.FromID, Type: NODE_DEVICE, FromID: c.FromID, Implicit: true}
+		}
+	}
+	byId := make(map[string]*model.Channel, len(channels))
+	for _, c := range channels {
+		byId[c.DeviceID] = c
+	}
+
+	//按编码倒序挂接，结果与map遍历顺序无关
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	roots := make([]*TreeNode, 0)
+	for _, id := range ids {
+		n := nodes[id]
+		parent := nodes[parentOf(n, byId[n.ID], nodes)]
+		if parent == nil || parent == n || isAncestor(n, parent, nodes) {
+			n.ParentID = ""
+			roots = append(roots, n)
+			continue
+		}
+		n.ParentID = parent.ID
+	}
+	for _, n := range nodes {
+		if parent, ok := nodes[n.ParentID]; ok && n.ParentID != "" {
+			parent.Children = append(parent.Children, n)
+		}
+	}
+	sortNodes(roots)
+	for _, n := range roots {
+		count(n)
+	}
+	return roots
+}
+
+func nodeType(c *model.Channel) string {
+	if gbid.IsCivilCode(c.DeviceID) {
+		return NODE_CIVIL
+	}
+	switch {
+	case c.TypeCode == gbid.TYPE_BUSINESS_GROUP:
+		return NODE_BUSINESS_GROUP
+	case c.TypeCode == gbid.TYPE_VIRTUAL_ORG:
+		return NODE_VIRTUAL_ORG
+	case c.Category == gbid.CATEGORY_DEVICE || c.Category == gbid.CATEGORY_PLATFORM:
+		return NODE_DEVICE
+	}
+	return NODE_CHANNEL
+}
+
+func parentOf(n *TreeNode, c *model.Channel, nodes map[string]*TreeNode) string {
+	if n.Type == NODE_CIVIL {
+		for l := len(n.ID) - 2; l >= 2; l -= 2 {
+			if _, ok := nodes[n.ID[:l]]; ok {
+				return n.ID[:l]
+			}
+		}
+		return ""
+	}
+	//FromID补充的设备节点
+	if c == nil {
+		return ""
+	}
+	if c.ParentID != "" {
+		levels := strings.Split(c.ParentID, "/")
+		for i := len(levels) - 1; i >= 0; i-- {
+			if _, ok := nodes[levels[i]]; ok && levels[i] != n.ID {
+				return levels[i]
+			}
+		}
+	}
+	if n.Type == NODE_BUSINESS_GROUP || n.Type == NODE_DEVICE {
+		if _, ok := nodes[c.CivilCode]; ok {
+			return c.CivilCode
+		}
+	}
+	if n.Type != NODE_BUSINESS_GROUP && c.FromID != n.ID {
+		return c.FromID
+	}
+	return ""
+}
+
+//node是否为parent的祖先，用于避免ParentID成环
+func isAncestor(node, parent *TreeNode, nodes map[string]*TreeNode) bool {
+	seen := make(map[string]bool)
+	for p := parent; p != nil && p.ParentID != "" && !seen[p.ID]; p = nodes[p.ParentID] {
+		seen[p.ID] = true
+		if p.ParentID == node.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func sortNodes(nodes []*TreeNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodeOrder[nodes[i].Type] != nodeOrder[nodes[j].Type] {
+			return nodeOrder[nodes[i].Type] < nodeOrder[nodes[j].Type]
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+	for _, n := range nodes {
+		sortNodes(n.Children)
+	}
+}
+
+func count(n *TreeNode) int {
+	n.ChannelCount = 0
+	if n.Type == NODE_CHANNEL {
+		n.ChannelCount = 1
+	}
+	for _, child := range n.Children {
+		n.ChannelCount += count(child)
+	}
+	return n.ChannelCount
+}
+
+func findNode(nodes []*TreeNode, id string) *TreeNode {
+	for _, n := range nodes {
+		if n.ID == id {
+			return n
+		}
+		if found := findNode(n.Children, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func truncate(n *TreeNode, depth int) {
+	if depth <= 1 {
+		n.Children = nil
+		return
+	}
+	for _, child := range n.Children {
+		truncate(child, depth-1)
+	}
+}
@@ -0,0 +1,152 @@
+package catalog
+
+import (
+	"dyzs/galaxy/gbid"
+	"dyzs/galaxy/model"
+	"strings"
+	"testing"
+)
+
+func newChannel(fromId, id, name, parentId, civilCode string) *model.Channel {
+	c := &model.Channel{FromID: fromId, DeviceID: id, Name: name, ParentID: parentId, CivilCode: civilCode, Status: "ON"}
+	if g, err := gbid.Parse(id); err == nil {
+		c.TypeCode = g.TypeCode
+		c.Category = g.Category()
+	}
+	return c
+}
+
+//按名称输出树结构，补充的节点带*
+func render(nodes []*TreeNode) string {
+	parts := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		s := n.Name
+		if n.Implicit {
+			s += "*"
+		}
+		if len(n.Children) > 0 {
+			s += "(" + render(n.Children) + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestBuildTree(t *testing.T) {
+	const (
+		group   = "34020000002150000001"
+		org     = "34020000002160000001"
+		org2    = "34020000002160000002"
+		nvr     = "34020000001180000001"
+		camera1 = "34020000001320000001"
+		camera2 = "34020000001320000002"
+		camera3 = "34020000001320000003"
+		camera4 = "34020000001320000004"
+	)
+	cases := []struct {
+		name     string
+		channels []*model.Channel
+		want     string
+		counts   map[string]int
+	}{
+		{
+			name: "ParentID成环时编码最小的节点作为根",
+			channels: []*model.Channel{
+				newChannel("P", org, "A", org2, ""),
+				newChannel("P", org2, "B", org, ""),
+			},
+			want: "A(B),P*",
+		},
+		{
+			name: "三个节点成环",
+			channels: []*model.Channel{
+				newChannel("P", org, "A", org2, ""),
+				newChannel("P", org2, "B", camera1, ""),
+				newChannel("P", camera1, "C", org, ""),
+			},
+			want:   "P*,C(B(A))",
+			counts: map[string]int{"C": 1},
+		},
+		{
+			name: "多级ParentID取最后一个存在的节点",
+			channels: []*model.Channel{
+				newChannel("P", "34", "34", "", ""),
+				newChannel("P", group, "G", "", "340200"),
+				newChannel("P", org, "V", group, ""),
+				newChannel("P", camera1, "C1", group+"/"+org, ""),
+				newChannel("P", camera2, "C2", group+"/"+org+"/missing", ""),
+				newChannel("P", camera3, "C3", group+"/missing", ""),
+			},
+			want:   "34(340200*(G(V(C1,C2),C3))),P*",
+			counts: map[string]int{"34": 3, "G": 3, "V": 2, "P": 0},
+		},
+		{
+			name: "设备缺少行政区划时挂到来源设备",
+			channels: []*model.Channel{
+				newChannel("P", nvr, "D", "", ""),
+				newChannel("P", camera4, "C4", nvr, ""),
+				newChannel("P", camera1, "C1", "", ""),
+			},
+			want:   "P*(D(C4),C1)",
+			counts: map[string]int{"P": 2, "D": 1},
+		},
+		{
+			name: "ParentID指向自身",
+			channels: []*model.Channel{
+				newChannel("P", camera1, "C1", camera1, ""),
+			},
+			want: "P*(C1)",
+		},
+	}
+	for _, c := range cases {
+		//多次构建，结果与map遍历顺序无关
+		for i := 0; i < 20; i++ {
+			roots := BuildTree(c.channels)
+			if got := render(roots); got != c.want {
+				t.Fatalf("%s：第%d次构建为%s，期望%s", c.name, i+1, got, c.want)
+			}
+			for name, want := range c.counts {
+				n := findByName(roots, name)
+				if n == nil || n.ChannelCount != want {
+					t.Fatalf("%s：%s的通道数错误：%+v", c.name, name, n)
+				}
+			}
+		}
+	}
+}
+
+func findByName(nodes []*TreeNode, name string) *TreeNode {
+	for _, n := range nodes {
+		if n.Name == name {
+			return n
+		}
+		if found := findByName(n.Children, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestTreeQuery(t *testing.T) {
+	r := NewRegistry(NewStore())
+	r.Put(
+		newChannel("P", "34020000002150000001", "G", "", ""),
+		newChannel("P", "34020000001320000001", "C1", "34020000002150000001", ""),
+		newChannel("Q", "34020000001320000002", "C2", "", ""),
+	)
+	cases := []struct {
+		query *TreeQuery
+		want  string
+	}{
+		{nil, "G(C1),P*,Q*(C2)"},
+		{&TreeQuery{FromID: "Q"}, "Q*(C2)"},
+		{&TreeQuery{Root: "34020000002150000001"}, "G(C1)"},
+		{&TreeQuery{Root: "missing"}, ""},
+		{&TreeQuery{Depth: 1}, "G,P*,Q*"},
+	}
+	for _, c := range cases {
+		if got := render(r.Tree(c.query)); got != c.want {
+			t.Errorf("Tree(%+v) = %s，期望%s", c.query, got, c.want)
+		}
+	}
+}
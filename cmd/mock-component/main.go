@@ -0,0 +1,43 @@
+//模拟任务组件镜像入口，行为通过环境变量配置：
+//  MOCK_INIT_DELAY    init响应延迟，如 3s
+//  MOCK_INIT_ERROR    init返回的错误
+//  MOCK_HEART_FAIL    第几次心跳之后开始失败
+//  MOCK_REJECT        拒绝下发的资源ID，逗号分隔，*表示全部
+//  MOCK_ECHO          为true时/cmd原样返回指令参数
+package main
+
+import (
+	"dyzs/galaxy/mock/component"
+	"dyzs/galaxy/sdk"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	behavior := component.Behavior{
+		InitError:       os.Getenv("MOCK_INIT_ERROR"),
+		RejectResources: make(map[string]bool),
+		EchoCommands:    os.Getenv("MOCK_ECHO") == "true",
+	}
+	if d, err := time.ParseDuration(os.Getenv("MOCK_INIT_DELAY")); err == nil {
+		behavior.InitDelay = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("MOCK_HEART_FAIL")); err == nil {
+		behavior.HeartFailAfter = n
+	}
+	for _, id := range strings.Split(os.Getenv("MOCK_REJECT"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "*" {
+			behavior.RejectAll = true
+		} else if id != "" {
+			behavior.RejectResources[id] = true
+		}
+	}
+	err := sdk.NewServer(component.New(behavior)).Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -7,3 +7,8 @@ var DB_DATASET_TASK = "t_task"
 var REDIS_KEY_TASKS = "galaxy_tasks"
 var REDIS_KEY_CENTERHOST = "center_host"
 var REDIS_KEY_BOXID = "box_id"
+var REDIS_KEY_ROUTES = "galaxy_routes"
+var REDIS_KEY_BOX_CREDENTIAL = "box_credential"
+var REDIS_KEY_OUTBOX = "galaxy_outbox"
+var REDIS_KEY_CATALOG_DEVICES = "galaxy_catalog_devices"
+var REDIS_KEY_CATALOG_PREFIX = "galaxy_catalog:"
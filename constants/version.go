@@ -0,0 +1,4 @@
+package constants
+
+//galaxy版本，构建时通过 -ldflags "-X dyzs/galaxy/constants.VERSION=x.y.z" 设置
+var VERSION = "dev"
@@ -0,0 +1,129 @@
+package dispatcher
+
+import (
+	"dyzs/galaxy/model"
+	"errors"
+	"sort"
+)
+
+var ErrTaskNotFound = errors.New("任务不存在")
+var ErrWorkerNotFound = errors.New("任务未绑定执行器")
+var ErrTaskNotInited = errors.New("任务未初始化")
+
+//任务详情，不含资源内容
+type TaskInfo struct {
+	model.Task
+	Resources int              `json:"resources"` //任务资源数
+	State     *model.TaskState `json:"state"`
+}
+
+//执行器状态
+type WorkerStatus struct {
+	TaskId     string   `json:"taskId"`
+	ManagePort int      `json:"managePort"`
+	Address    string   `json:"address"` //管理接口地址
+	Running    bool     `json:"running"` //容器已启动
+	Inited     bool     `json:"inited"`  //已初始化并下发资源
+	Image      string   `json:"image"`
+	ResourceId string   `json:"resourceId"`
+	Resources  int      `json:"resources"` //已下发的资源数
+	ApiVersion int      `json:"apiVersion"`
+	Features   []string `json:"features"`
+}
+
+//当前任务列表，按id排序
+func (td *TaskDispatcher) Tasks() []*TaskInfo {
+	tasks := td.getCurrentTasks()
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].ID < tasks[j].ID
+	})
+	res := make([]*TaskInfo, 0, len(tasks))
+	for _, t := range tasks {
+		res = append(res, td.taskInfo(t))
+	}
+	return res
+}
+
+//查询任务
+func (td *TaskDispatcher) Task(taskId string) (*TaskInfo, bool) {
+	t := td.GetTaskById(taskId)
+	if t == nil {
+		return nil, false
+	}
+	return td.taskInfo(t), true
+}
+
+//执行器状态列表，按任务id排序
+func (td *TaskDispatcher) Workers() []*WorkerStatus {
+	td.Lock()
+	workers := make([]*Worker, 0, len(td.taskBinding))
+	for _, w := range td.taskBinding {
+		workers = append(workers, w)
+	}
+	td.Unlock()
+	res := make([]*WorkerStatus, 0, len(workers))
+	for _, w := range workers {
+		res = append(res, w.status())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].TaskId < res[j].TaskId
+	})
+	return res
+}
+
+//重启任务容器，执行器在下次检查时重新创建容器并初始化
+func (td *TaskDispatcher) RestartTask(taskId string) error {
+	w, err := td.worker(taskId)
+	if err != nil {
+		return err
+	}
+	w.restart()
+	return nil
+}
+
+//重新初始化任务，执行器在下次检查时重新调用init并全量下发资源
+func (td *TaskDispatcher) ReinitTask(taskId string) error {
+	w, err := td.worker(taskId)
+	if err != nil {
+		return err
+	}
+	w.reinit()
+	return nil
+}
+
+//向任务容器全量重新下发资源
+func (td *TaskDispatcher) ResyncResources(taskId string) error {
+	w, err := td.worker(taskId)
+	if err != nil {
+		return err
+	}
+	return w.resync()
+}
+
+func (td *TaskDispatcher) worker(taskId string) (*Worker, error) {
+	if td.GetTaskById(taskId) == nil {
+		return nil, ErrTaskNotFound
+	}
+	td.Lock()
+	defer td.Unlock()
+	w, ok := td.taskBinding[taskId]
+	if !ok {
+		return nil, ErrWorkerNotFound
+	}
+	return w, nil
+}
+
+func (td *TaskDispatcher) taskInfo(t *model.Task) *TaskInfo {
+	info := &TaskInfo{
+		Task:      *t,
+		Resources: len(t.GetResources()),
+	}
+	info.ResourceBytes = ""
+	td.Lock()
+	if ts, ok := td.taskStates[t.ID]; ok {
+		copyState := *ts
+		info.State = &copyState
+	}
+	td.Unlock()
+	return info
+}
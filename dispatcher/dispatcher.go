@@ -5,8 +5,13 @@ import (
 	"dyzs/galaxy/constants"
 	"dyzs/galaxy/logger"
 	"dyzs/galaxy/model"
+	"dyzs/galaxy/monitor"
 	"dyzs/galaxy/proxy"
 	"dyzs/galaxy/redis"
+	"dyzs/galaxy/resilience"
+	"dyzs/galaxy/router"
+	"dyzs/galaxy/schema"
+	"dyzs/galaxy/util"
 	"github.com/spf13/viper"
 	"net/http"
 	"sync"
@@ -16,17 +21,35 @@ import (
 var TASK_CONTAINER_PREFIX = "task_"
 var _DEFAULT_HEART_INTERVAL = 30 //默认心跳间隔30s
 
+//心跳状态
+type HeartStatus struct {
+	Failures    int       `json:"failures"` //连续失败次数
+	LastError   string    `json:"lastError,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	NextHeart   time.Time `json:"nextHeart"`
+}
+
 type TaskDispatcher struct {
 	httpClient  *http.Client
 	redisClient *redis.Cache
 
 	sync.Mutex
 	Host          string
+	Runtime       Runtime           //任务容器运行时，默认docker
+	CenterProxy   proxy.CenterProxy //中心代理，默认按配置创建
 	ctx           context.Context
 	cancel        context.CancelFunc
 	taskMap       map[string]*model.Task
 	taskResources map[string][]*model.Resource
 	taskBinding   map[string]*Worker
+	taskStates    map[string]*model.TaskState
+	routeDeclares map[string]*RouteDeclaration
+	routes        *router.Table
+	monitor       *monitor.Collector
+	heartStatus   HeartStatus
+	nodeId        string
+	heartC        chan struct{}
+	pushC         chan []*model.Task
 }
 
 //初始化
@@ -41,9 +64,29 @@ func (td *TaskDispatcher) Init() {
 		Timeout: 3 * time.Second,
 	}
 	td.redisClient = redis.NewRedisCache(0, viper.GetString("redis.addr"), redis.FOREVER)
+	if td.Runtime == nil {
+		td.Runtime = &DockerRuntime{}
+	}
+	if td.CenterProxy == nil {
+		td.CenterProxy = proxy.NewCenterProxy()
+	}
+	td.monitor = monitor.NewCollector()
+	td.heartC = make(chan struct{}, 1)
+	td.pushC = make(chan []*model.Task)
 	td.ctx, td.cancel = context.WithCancel(context.Background())
 	td.taskMap = make(map[string]*model.Task)
 	td.taskBinding = make(map[string]*Worker)
+	td.taskStates = make(map[string]*model.TaskState)
+	td.routeDeclares = make(map[string]*RouteDeclaration)
+	//路由表，先加载重启前的快照
+	td.routes = router.NewTable(td.Runtime.Address, td.redisClient, viper.GetString("route.conflictPolicy"))
+	td.routes.Load()
+	//加载接入参数schema
+	schemaDir := viper.GetString("accessSchemaDir")
+	if schemaDir == "" {
+		schemaDir = util.GetAppPath() + "schemas"
+	}
+	schema.LoadDir(schemaDir)
 	//从本地redis获取任务信息
 	td.loadLocalTasks()
 	//轮询更新任务
@@ -52,6 +95,11 @@ func (td *TaskDispatcher) Init() {
 	go td.loopBindTask()
 }
 
+//停止调度，关闭所有任务
+func (td *TaskDispatcher) Stop() {
+	td.cancel()
+}
+
 //加载本地任务列表
 func (td *TaskDispatcher) loadLocalTasks() {
 	localTasks := make([]*model.Task, 0)
@@ -67,37 +115,148 @@ func (td *TaskDispatcher) loadLocalTasks() {
 	td.refreshTasks(localTasks)
 }
 
-//轮询变更任务
+//轮询变更任务，中心推送任务变更时立即处理
 func (td *TaskDispatcher) loopFindTask() {
-	centerProxy := proxy.NewCenterProxy()
 	heartInterval := viper.GetInt("center.heartInterval")
 	if heartInterval <= 0 {
 		heartInterval = _DEFAULT_HEART_INTERVAL
 	}
-	var inited bool
+	var changes <-chan struct{}
+	if notifier, ok := td.CenterProxy.(proxy.Notifier); ok {
+		changes = notifier.Changes()
+	}
+	//心跳失败后按指数退避重试，默认从5s开始，不超过心跳间隔
+	interval := time.Duration(heartInterval) * time.Second
+	backoff := resilience.NewBackoff(5 * time.Second)
+	if backoff.Max > interval {
+		backoff.Max = interval
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	for {
-		if inited {
-			time.Sleep(time.Duration(heartInterval) * time.Second)
-		}
-		inited = true
 		select {
 		case <-td.ctx.Done():
 			return
-		default:
+		case tasks := <-td.pushC:
+			//中心推送的完整任务，补全资源后直接刷新
+			td.CenterProxy.PrepareTasks(td.getCurrentTasks(), tasks, td.getNodeId())
+			td.refreshTasks(tasks)
+			continue
+		case <-changes:
+			td.TriggerHeart()
+			continue
+		case <-td.heartC:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timer.C:
 		}
-		hr, err := centerProxy.Heart(td.getCurrentTasks())
-		if err != nil {
+		next := interval
+		if failures := td.heart(); failures > 0 {
+			//抖动可能超过Max
+			if d := backoff.Next(failures); d < interval {
+				next = d
+			}
+		}
+		td.Lock()
+		td.heartStatus.NextHeart = time.Now().Add(next)
+		td.Unlock()
+		timer.Reset(next)
+	}
+}
+
+//发送心跳并刷新任务，返回连续失败次数；只在首次失败和恢复时记录警告
+func (td *TaskDispatcher) heart() int {
+	hr, err := td.CenterProxy.Heart(td.getCurrentTasks(), td.heartReport())
+	td.Lock()
+	if err != nil {
+		td.heartStatus.Failures++
+		td.heartStatus.LastError = err.Error()
+		failures := td.heartStatus.Failures
+		td.Unlock()
+		if failures == 1 {
 			logger.LOG_WARN("发送中心心跳请求失败，", err)
-			continue
+		} else {
+			logger.LOG_INFO("发送中心心跳请求失败，连续", failures, "次，", err)
 		}
-		err = td.redisClient.StringSet(constants.REDIS_KEY_BOXID, hr.Node.Id)
-		if err != nil {
-			logger.LOG_ERROR("BoxId缓存入redis异常，", err)
+		return failures
+	}
+	if td.heartStatus.Failures > 0 {
+		logger.LOG_WARN("中心心跳恢复，此前连续失败", td.heartStatus.Failures, "次")
+	}
+	td.heartStatus.Failures = 0
+	td.heartStatus.LastError = ""
+	td.heartStatus.LastSuccess = time.Now()
+	td.nodeId = hr.Node.Id
+	td.Unlock()
+	err = td.redisClient.StringSet(constants.REDIS_KEY_BOXID, hr.Node.Id)
+	if err != nil {
+		logger.LOG_ERROR("BoxId缓存入redis异常，", err)
+	}
+	td.refreshTasks(hr.Tasks)
+	return 0
+}
+
+//心跳状态
+func (td *TaskDispatcher) HeartStatus() HeartStatus {
+	td.Lock()
+	defer td.Unlock()
+	return td.heartStatus
+}
+
+//心跳上报内容：任务状态（含容器状态、资源数）、路由冲突、盒子运行状况、运行时状态
+func (td *TaskDispatcher) heartReport() *proxy.HeartReport {
+	report := &proxy.HeartReport{
+		Conflicts: td.routes.Conflicts(),
+		Stats:     td.monitor.Collect(),
+	}
+	if sr, ok := td.Runtime.(StatusReporter); ok {
+		report.Runtime = sr.Status()
+	}
+	td.Lock()
+	workers := make(map[string]*Worker, len(td.taskBinding))
+	for k, v := range td.taskBinding {
+		workers[k] = v
+	}
+	td.Unlock()
+	for _, ts := range td.getTaskStates() {
+		copyState := *ts
+		if w := workers[ts.TaskID]; w != nil {
+			copyState.Resources = w.resourceCount()
+		}
+		if report.Runtime != nil {
+			copyState.Container = report.Runtime.Containers[ts.TaskID]
 		}
-		td.refreshTasks(hr.Tasks)
+		report.TaskStates = append(report.TaskStates, &copyState)
+	}
+	return report
+}
+
+//立即发送心跳，用于中心通知任务变更
+func (td *TaskDispatcher) TriggerHeart() {
+	select {
+	case td.heartC <- struct{}{}:
+	default:
 	}
 }
 
+//接收中心推送的任务
+func (td *TaskDispatcher) PushTasks(tasks []*model.Task) {
+	select {
+	case td.pushC <- tasks:
+	case <-td.ctx.Done():
+	}
+}
+
+func (td *TaskDispatcher) getNodeId() string {
+	td.Lock()
+	defer td.Unlock()
+	return td.nodeId
+}
+
 //获取当前正在执行的任务列表
 func (td *TaskDispatcher) getCurrentTasks() (localTasks []*model.Task) {
 	td.Lock()
@@ -126,10 +285,13 @@ func (td *TaskDispatcher) refreshTasks(tasks []*model.Task) {
 			}
 			//新增或变更任务
 			if !ok || oldTask.UpdateTime != t.UpdateTime || oldTask.ResourceId != t.ResourceId || oldTask.ResourceBytes != t.ResourceBytes {
+				//加入任务列表前解析资源，路由和执行器只读取解析结果
+				t.ParseResources()
 				td.taskMap[t.ID] = t
 			}
 		}
 		td.Unlock()
+		td.rebuildRoutes()
 		//save to redis
 		var localTasks []*model.Task
 		for _, v := range td.taskMap {
@@ -160,6 +322,61 @@ func (td *TaskDispatcher) ReleaseTask(taskId string) {
 		td.Unlock()
 	}()
 	delete(td.taskBinding, taskId)
+	delete(td.taskStates, taskId)
+	delete(td.routeDeclares, taskId)
+}
+
+//更新任务运行状态
+func (td *TaskDispatcher) setTaskState(taskId, state, message string) {
+	td.Lock()
+	defer td.Unlock()
+	old, ok := td.taskStates[taskId]
+	if ok && old.State == state && old.Message == message {
+		return
+	}
+	ts := &model.TaskState{
+		TaskID:     taskId,
+		State:      state,
+		Message:    message,
+		UpdateTime: time.Now().UnixNano() / 1e6,
+	}
+	if ok {
+		ts.ApiVersion = old.ApiVersion
+		ts.Health = old.Health
+	}
+	td.taskStates[taskId] = ts
+}
+
+//记录任务协商的管理接口版本
+func (td *TaskDispatcher) setTaskApiVersion(taskId string, apiVersion int) {
+	td.Lock()
+	defer td.Unlock()
+	if ts, ok := td.taskStates[taskId]; ok {
+		copyState := *ts
+		copyState.ApiVersion = apiVersion
+		td.taskStates[taskId] = &copyState
+	}
+}
+
+//记录任务健康详情
+func (td *TaskDispatcher) setTaskHealth(taskId string, health interface{}) {
+	td.Lock()
+	defer td.Unlock()
+	if ts, ok := td.taskStates[taskId]; ok {
+		copyState := *ts
+		copyState.Health = health
+		td.taskStates[taskId] = &copyState
+	}
+}
+
+//获取任务运行状态列表
+func (td *TaskDispatcher) getTaskStates() (states []*model.TaskState) {
+	td.Lock()
+	for _, v := range td.taskStates {
+		states = append(states, v)
+	}
+	td.Unlock()
+	return states
 }
 
 //绑定任务到执行器
@@ -189,6 +406,11 @@ func (td *TaskDispatcher) loopBindTask() {
 				}
 				newWorkers = append(newWorkers, worker)
 				td.taskBinding[nt.ID] = worker
+				td.taskStates[nt.ID] = &model.TaskState{
+					TaskID:     nt.ID,
+					State:      model.TASK_STATE_PENDING,
+					UpdateTime: time.Now().UnixNano() / 1e6,
+				}
 			}
 			td.Unlock()
 			for _, w := range newWorkers {
@@ -0,0 +1,24 @@
+package dispatcher
+
+//任务运行状态，未上报时为空
+func (td *TaskDispatcher) TaskState(taskId string) string {
+	td.Lock()
+	defer td.Unlock()
+	if ts, ok := td.taskStates[taskId]; ok {
+		return ts.State
+	}
+	return ""
+}
+
+//任务的执行器是否已完成init
+func (td *TaskDispatcher) TaskInited(taskId string) bool {
+	td.Lock()
+	w, ok := td.taskBinding[taskId]
+	td.Unlock()
+	if !ok {
+		return false
+	}
+	w.Lock()
+	defer w.Unlock()
+	return w.taskInited
+}
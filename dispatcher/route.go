@@ -0,0 +1,121 @@
+package dispatcher
+
+import (
+	"dyzs/galaxy/gbid"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/router"
+	"sort"
+)
+
+//路由键类型
+const (
+	ROUTE_KEY_RESOURCE_ID = "resourceId"
+	ROUTE_KEY_GB_ID       = "gbId"
+	ROUTE_KEY_CHANNEL_ID  = "channelId"
+)
+
+var _DEFAULT_ROUTE_KEYS = []string{ROUTE_KEY_RESOURCE_ID, ROUTE_KEY_GB_ID, ROUTE_KEY_CHANNEL_ID}
+
+//任务声明的路由范围，由任务init响应返回，未声明时按默认键类型路由全部资源
+type RouteDeclaration struct {
+	Keys []string `json:"routeKeys"` //按哪些资源编号路由到该任务
+	Ids  []string `json:"routeIds"`  //额外服务的编号，如平台自身国标编码
+}
+
+func (rd *RouteDeclaration) has(key string) bool {
+	for _, k := range rd.Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+//设置任务声明的路由范围，并重建路由表
+func (td *TaskDispatcher) declareRoutes(taskId string, rd *RouteDeclaration) {
+	td.routes.Settle(taskId)
+	td.Lock()
+	if rd == nil || len(rd.Keys) == 0 && len(rd.Ids) == 0 {
+		delete(td.routeDeclares, taskId)
+	} else {
+		td.routeDeclares[taskId] = rd
+	}
+	td.Unlock()
+	td.rebuildRoutes()
+}
+
+//根据任务列表重建路由表，未重新init的任务沿用快照路由
+func (td *TaskDispatcher) rebuildRoutes() {
+	table := make(map[string][]string)
+	add := func(key, taskId string) {
+		if key == "" {
+			return
+		}
+		for _, id := range table[key] {
+			if id == taskId {
+				return
+			}
+		}
+		table[key] = append(table[key], taskId)
+	}
+	td.Lock()
+	//按创建时间排序，先创建的任务为主任务
+	tasks := make([]*model.Task, 0, len(td.taskMap))
+	taskIds := make([]string, 0, len(td.taskMap))
+	for _, t := range td.taskMap {
+		tasks = append(tasks, t)
+		taskIds = append(taskIds, t.ID)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].CreateTime != tasks[j].CreateTime {
+			return tasks[i].CreateTime < tasks[j].CreateTime
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	for _, t := range tasks {
+		rd, ok := td.routeDeclares[t.ID]
+		if !ok || len(rd.Keys) == 0 {
+			rd = &RouteDeclaration{Keys: _DEFAULT_ROUTE_KEYS, Ids: rd.idsOrNil()}
+		}
+		for _, id := range rd.Ids {
+			add(id, t.ID)
+		}
+		for _, r := range t.GetResources() {
+			if rd.has(ROUTE_KEY_RESOURCE_ID) {
+				add(r.ID, t.ID)
+			}
+			if rd.has(ROUTE_KEY_GB_ID) {
+				add(r.GbID, t.ID)
+			}
+			if rd.has(ROUTE_KEY_CHANNEL_ID) {
+				//通道号仅在为国标编码时全局唯一，才可作为路由键
+				for _, ch := range r.ChannelIds() {
+					if gbid.Valid(ch) {
+						add(ch, t.ID)
+					}
+				}
+			}
+		}
+	}
+	td.Unlock()
+	td.routes.Rebuild(table, taskIds)
+	logger.LOG_INFO("重建路由表，路由数：", len(table))
+}
+
+func (rd *RouteDeclaration) idsOrNil() []string {
+	if rd == nil {
+		return nil
+	}
+	return rd.Ids
+}
+
+//通过资源编号/国标编码/通道编号查找任务地址
+func (td *TaskDispatcher) GetTaskAddress(key string) string {
+	return td.routes.Address(key)
+}
+
+//路由表
+func (td *TaskDispatcher) Routes() *router.Table {
+	return td.routes
+}
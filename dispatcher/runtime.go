@@ -0,0 +1,135 @@
+package dispatcher
+
+import (
+	"bytes"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/util"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//任务容器启动参数
+type ContainerSpec struct {
+	TaskID     string
+	Name       string
+	Image      string
+	Ports      []int
+	Network    string
+	Env        map[string]string
+	Volumes    []string
+	ManagePort int
+}
+
+//任务容器运行时
+type Runtime interface {
+	//启动容器，已存在同名容器时先停止
+	Start(spec *ContainerSpec) error
+	//停止并删除容器
+	Stop(taskId string) error
+	//容器管理接口地址，格式 host:port
+	Address(taskId string, managePort int) string
+}
+
+//可选：运行时状态，随心跳上报
+type StatusReporter interface {
+	Status() *model.RuntimeStatus
+}
+
+//docker运行时
+type DockerRuntime struct{}
+
+func (dr *DockerRuntime) Start(spec *ContainerSpec) error {
+	//stop container
+	cmdRes, err := util.ExecCmd(fmt.Sprintf("docker stop %s", spec.Name))
+	if err != nil {
+		logger.LOG_WARN("关闭容器异常：", err)
+	} else {
+		logger.LOG_WARN("关闭容器成功：", cmdRes)
+	}
+	time.Sleep(5 * time.Second)
+	//create container
+	var cmd bytes.Buffer
+	cmd.WriteString("docker run --rm -d ")
+	//ports
+	for _, p := range spec.Ports {
+		ps := strconv.Itoa(p)
+		cmd.WriteString(" -p " + ps + ":" + ps + " -p " + ps + ":" + ps + "/udp ")
+	}
+	//network
+	if spec.Network != "" {
+		cmd.WriteString(" --network " + spec.Network + " ")
+	}
+	//name
+	cmd.WriteString(" --name=" + spec.Name)
+	//env
+	keys := make([]string, 0, len(spec.Env))
+	for k := range spec.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.WriteString(" -e " + k + "=" + spec.Env[k] + " ")
+	}
+	//volume
+	for _, v := range spec.Volumes {
+		cmd.WriteString(" -v " + v + " ")
+	}
+	//image
+	cmd.WriteString(spec.Image)
+
+	cmdRes, err = util.ExecCmd(cmd.String())
+	if err != nil {
+		return err
+	}
+	logger.LOG_WARN("启动容器成功：", cmdRes)
+	return nil
+}
+
+func (dr *DockerRuntime) Stop(taskId string) error {
+	name := TASK_CONTAINER_PREFIX + taskId
+	cmdRes, err := util.ExecCmd(fmt.Sprintf("docker stop %s", name))
+	if err != nil {
+		logger.LOG_WARN("关闭容器异常：", err)
+	} else {
+		logger.LOG_WARN("关闭容器成功：", cmdRes)
+	}
+	_, _ = util.ExecCmd(fmt.Sprintf("docker rm %s", name))
+	return err
+}
+
+//同一docker网络内按容器名访问
+func (dr *DockerRuntime) Address(taskId string, managePort int) string {
+	return TASK_CONTAINER_PREFIX + taskId + ":" + strconv.Itoa(managePort)
+}
+
+//docker服务状态及任务容器状态
+func (dr *DockerRuntime) Status() *model.RuntimeStatus {
+	rs := &model.RuntimeStatus{
+		Name:       "docker",
+		Containers: make(map[string]string),
+	}
+	version, err := util.ExecCmd("docker version --format '{{.Server.Version}}'")
+	if err != nil {
+		rs.Message = err.Error()
+		return rs
+	}
+	rs.Available = true
+	rs.Version = version
+	ps, err := util.ExecCmd("docker ps -a --filter name=" + TASK_CONTAINER_PREFIX + " --format '{{.Names}}\t{{.State}}'")
+	if err != nil {
+		rs.Message = err.Error()
+		return rs
+	}
+	for _, line := range strings.Split(ps, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], TASK_CONTAINER_PREFIX) {
+			continue
+		}
+		rs.Containers[strings.TrimPrefix(fields[0], TASK_CONTAINER_PREFIX)] = fields[1]
+	}
+	return rs
+}
@@ -0,0 +1,120 @@
+package dispatcher_test
+
+import (
+	"dyzs/galaxy/dispatcher"
+	"dyzs/galaxy/mock/component"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/proxy"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//固定返回任务列表的中心代理
+type stubCenter struct {
+	sync.Mutex
+	tasks  []*model.Task
+	hearts int
+}
+
+func (sc *stubCenter) Heart(localTasks []*model.Task, report *proxy.HeartReport) (*proxy.HeartResonse, error) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.hearts++
+	return &proxy.HeartResonse{Node: model.Node{Id: "box"}, Tasks: sc.tasks}, nil
+}
+
+func (sc *stubCenter) PrepareTasks(localTasks []*model.Task, tasks []*model.Task, nodeId string) {}
+
+func mockTask(id string, resourceIds ...string) *model.Task {
+	rows := make([]string, 0, len(resourceIds))
+	for _, r := range resourceIds {
+		rows = append(rows, r+",,,,,,,127.0.0.1,554,admin,admin,")
+	}
+	return &model.Task{
+		ID:            id,
+		Name:          id,
+		Repository:    "mock",
+		AccessType:    "mock",
+		Status:        model.TASK_STATUS_RUNNING,
+		ResourceId:    id + "-resources",
+		ResourceBytes: strings.Join(rows, "\n"),
+		CreateTime:    1,
+		UpdateTime:    1,
+	}
+}
+
+//轮询直到条件满足，超时失败
+func waitFor(t *testing.T, timeout time.Duration, desc string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时：%s", desc)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func taskState(td *dispatcher.TaskDispatcher, taskId string) string {
+	return td.TaskState(taskId)
+}
+
+//模拟组件驱动调度器：init缓慢、心跳失败、拒绝资源
+func TestDispatcherScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("调度器场景测试依赖执行器轮询间隔，耗时较长")
+	}
+	rt := component.NewRuntime(component.Behavior{})
+	rt.SetBehavior("slow", component.Behavior{InitDelay: 1500 * time.Millisecond})
+	rt.SetBehavior("heart", component.Behavior{HeartFailAfter: 1})
+	rt.SetBehavior("reject", component.Behavior{RejectResources: map[string]bool{"r2": true}})
+	center := &stubCenter{tasks: []*model.Task{
+		mockTask("slow", "s1", "s2"),
+		mockTask("heart", "h1"),
+		mockTask("reject", "r1", "r2"),
+	}}
+	td := &dispatcher.TaskDispatcher{Runtime: rt, CenterProxy: center}
+	td.Init()
+	defer td.Stop()
+
+	t.Run("slowInit", func(t *testing.T) {
+		waitFor(t, 30*time.Second, "slow任务运行", func() bool {
+			return taskState(td, "slow") == model.TASK_STATE_RUNNING
+		})
+		c := rt.Component("slow")
+		if c == nil {
+			t.Fatal("slow任务组件未启动")
+		}
+		if n := c.InitCount(); n != 1 {
+			t.Errorf("init次数 %d，期望 1", n)
+		}
+		if n := len(c.Resources()); n != 2 {
+			t.Errorf("下发资源数 %d，期望 2", n)
+		}
+		if addr := td.GetTaskAddress("s1"); addr == "" {
+			t.Error("资源s1未路由到slow任务")
+		}
+	})
+
+	t.Run("failingHeart", func(t *testing.T) {
+		waitFor(t, 40*time.Second, "心跳失败后重启容器", func() bool {
+			return rt.Starts("heart") >= 2
+		})
+	})
+
+	t.Run("rejectedResources", func(t *testing.T) {
+		waitFor(t, 30*time.Second, "reject任务init失败", func() bool {
+			return taskState(td, "reject") == model.TASK_STATE_INIT_FAILED
+		})
+		if c := rt.Component("reject"); c != nil {
+			if _, ok := c.Resources()["r2"]; ok {
+				t.Error("被拒绝的资源r2不应被组件持有")
+			}
+		}
+		if td.TaskInited("reject") {
+			t.Error("资源被拒绝时任务不应标记为已初始化")
+		}
+	})
+}
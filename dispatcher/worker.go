@@ -3,8 +3,12 @@ package dispatcher
 import (
 	"bytes"
 	"context"
+	"dyzs/galaxy/gbid"
 	"dyzs/galaxy/logger"
+	"dyzs/galaxy/mapi"
 	"dyzs/galaxy/model"
+	"dyzs/galaxy/proxy"
+	"dyzs/galaxy/schema"
 	"dyzs/galaxy/util"
 	"errors"
 	"fmt"
@@ -22,15 +26,7 @@ var managePortPool = make(map[int]bool)
 var managePortStart = 32000
 var managePortPoolLock sync.Mutex
 
-var taskResources = make(map[string]map[string]bool)
-var taskManagePort = make(map[string]int)
-
-const (
-	_URL_INIT            = "http://%s:%s/mapi/init"
-	_URL_HEART           = "http://%s:%s/mapi/heart"
-	_URL_ASSIGN_RESOURCE = "http://%s:%s/mapi/assignResource"
-	_URL_REVOKE_RESOURCE = "http://%s:%s/mapi/revokeResource"
-)
+const _URL_MANAGE = "http://%s%s"
 
 //任务执行器
 type Worker struct {
@@ -41,31 +37,12 @@ type Worker struct {
 	workingTask *model.Task
 	taskInited  bool
 	managePort  int
+	capability  *mapi.Capability
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
-func GetTaskAddressByResourceId(resourceId string) (taskAddress string) {
-
-	//taskResources["gbaccess"] = map[string]bool{"34020000001320000001": true, "34020000001110000001": true}
-	//taskResources["onviftask"] = map[string]bool{"34020000001320000001":true}
-
-	var taskIds []string
-	for taskId, resourceIds := range taskResources {
-		if _, ok := resourceIds[resourceId]; ok {
-			taskIds = append(taskIds, taskId)
-		}
-	}
-	if len(taskIds) == 0 {
-		return ""
-	}
-	if len(taskIds) > 1 {
-		logger.LOG_WARN("资源下发到了多个任务，资源ID：", resourceId, ";任务Ids：", taskIds)
-	}
-	return TASK_CONTAINER_PREFIX + taskIds[0] + ":" + strconv.Itoa(taskManagePort[taskIds[0]]) //+ ":8000" //
-}
-
 //执行器启动
 func (w *Worker) start() {
 	//test
@@ -86,8 +63,9 @@ func (w *Worker) start() {
 	//return
 
 	w.ctx, w.cancel = context.WithCancel(w.td.ctx)
-	w.managePort = getNewManagePort()
-	taskManagePort[w.TaskId] = w.managePort
+	//优先沿用重启前的管理端口，保证路由快照可用
+	w.managePort = getManagePort(w.td.routes.Port(w.TaskId))
+	w.td.routes.SetPort(w.TaskId, w.managePort)
 	go w.bindTask()
 	go w.keepaliveTask()
 }
@@ -108,9 +86,18 @@ func (w *Worker) bindTask() {
 		if newTask == nil {
 			w.td.ReleaseTask(w.TaskId)
 			w.stopTask()
+			w.td.routes.RemovePort(w.TaskId)
+			revokeManagePort(w.managePort)
 			w.cancel()
 			return
 		}
+		//接入参数校验，不合法时不启动/更新任务
+		err := schema.Validate(newTask.AccessType, newTask.AccessParam)
+		if err != nil {
+			logger.LOG_WARN("任务接入参数校验失败，task:", w.TaskId, "，", err)
+			w.td.setTaskState(w.TaskId, model.TASK_STATE_INVALID_PARAM, err.Error())
+			continue
+		}
 		//任务未创建
 		var wt *model.Task
 		w.Lock()
@@ -137,26 +124,32 @@ func (w *Worker) bindTask() {
 			if err != nil {
 				//初始化失败，重新初始化
 				logger.LOG_WARN("任务init异常，", err)
+				w.td.setTaskState(w.TaskId, model.TASK_STATE_INIT_FAILED, err.Error())
+				w.Lock()
 				w.workingTask = nil
+				w.Unlock()
 				continue
 			} else {
 				w.Lock()
 				w.taskInited = true
 				w.Unlock()
+				w.td.setTaskState(w.TaskId, model.TASK_STATE_RUNNING, "")
+				w.td.routes.SetHealth(w.TaskId, true)
 			}
 		}
 		//任务无变更
 		if wt.UpdateTime == newTask.UpdateTime && wt.ResourceId == newTask.ResourceId {
 			continue
 		}
-		var err error
 		//任务配置变更
 		if wt.UpdateTime != newTask.UpdateTime && !compareTask(wt, newTask) {
 			err = w.initTask(newTask)
 			if err != nil {
 				logger.LOG_WARN("更新任务配置异常：", err)
+				w.td.setTaskState(w.TaskId, model.TASK_STATE_INIT_FAILED, err.Error())
 				continue
 			}
+			w.td.setTaskState(w.TaskId, model.TASK_STATE_RUNNING, "")
 		}
 		//任务资源变更
 		if wt.ResourceId != newTask.ResourceId {
@@ -211,6 +204,16 @@ func ComparePorts(a, b string) bool {
 	return true
 }
 
+//已下发的资源数
+func (w *Worker) resourceCount() int {
+	w.Lock()
+	defer w.Unlock()
+	if w.workingTask == nil || !w.taskInited {
+		return 0
+	}
+	return len(w.workingTask.GetResources())
+}
+
 //初始化任务
 func (w *Worker) initTask(task *model.Task) error {
 	copyTask := &model.Task{
@@ -230,17 +233,31 @@ func (w *Worker) initTask(task *model.Task) error {
 		NodeID:        task.NodeID,
 		ResourceBytes: "",
 	}
-	err := request(fmt.Sprintf(_URL_INIT, TASK_CONTAINER_PREFIX+task.ID, strconv.Itoa(w.managePort)), http.MethodPost, "application/json", copyTask, nil)
+	var msg jsoniter.RawMessage
+	err := requestWithHeader(w.manageUrl(mapi.PATH_INIT), http.MethodPost, "application/json", mapi.InitHeaders(), copyTask, &msg)
 	if err != nil {
 		return err
 	}
+	//能力协商
+	initRes := mapi.ParseInitResponse(msg)
+	capability := mapi.Negotiate(initRes)
+	logger.LOG_INFO("任务管理接口协商，task【", task.ID, "】,version：", capability.ApiVersion, ",features：", capability.Features)
+	w.Lock()
+	w.capability = capability
+	w.Unlock()
+	w.td.setTaskApiVersion(task.ID, capability.ApiVersion)
+	//任务可在init响应中声明路由范围
+	var rd *RouteDeclaration
+	if capability.Supports(mapi.FEATURE_ROUTE_DECLARE) {
+		rd = &RouteDeclaration{Keys: initRes.RouteKeys, Ids: initRes.RouteIds}
+	}
+	w.td.declareRoutes(task.ID, rd)
 	return w.refreshResource(nil, task)
 }
 
 //刷新资源
 func (w *Worker) refreshResource(oldTask, newTask *model.Task) error {
 	var oldResources []*model.Resource
-	var newResourceIds = make(map[string]bool)
 	if oldTask != nil {
 		oldResources = oldTask.GetResources()
 	} else {
@@ -271,8 +288,11 @@ func (w *Worker) refreshResource(oldTask, newTask *model.Task) error {
 	}
 	//新增
 	for _, r := range newResources {
-		newResourceIds[r.ID] = true
-		newResourceIds[r.GbID] = true
+		if r.GbID != "" {
+			if err := gbid.Validate(r.GbID); err != nil {
+				logger.LOG_WARN("资源国标编码不合法，task【", w.TaskId, "】,resource：", r.ID, ",gbId：", r.GbID, "，", err)
+			}
+		}
 		_, ok := oldResourceMap[r.ID]
 		if !ok {
 			addR = append(addR, r)
@@ -281,7 +301,7 @@ func (w *Worker) refreshResource(oldTask, newTask *model.Task) error {
 	//request remove
 	if len(removeR) > 0 {
 		logger.LOG_WARN("revoke resource，task【", w.TaskId, "】,count：", len(removeR))
-		err := request(fmt.Sprintf(_URL_REVOKE_RESOURCE, TASK_CONTAINER_PREFIX+w.TaskId, strconv.Itoa(w.managePort)), http.MethodPost, "application/json", removeR, nil)
+		err := request(w.manageUrl(mapi.PATH_REVOKE_RESOURCE), http.MethodPost, "application/json", removeR, nil)
 		if err != nil {
 			return err
 		}
@@ -291,24 +311,48 @@ func (w *Worker) refreshResource(oldTask, newTask *model.Task) error {
 	addR = append(addR, updateR...)
 	if len(addR) > 0 {
 		logger.LOG_WARN("assign resource，task【", w.TaskId, "】,count：", len(addR))
-		err := request(fmt.Sprintf(_URL_ASSIGN_RESOURCE, TASK_CONTAINER_PREFIX+w.TaskId, strconv.Itoa(w.managePort)), http.MethodPost, "application/json", addR, nil)
+		err := w.assignResource(addR)
 		if err != nil {
 			return err
 		}
 		logger.LOG_WARN("assign resource success")
 	}
+	return nil
+}
 
-	//缓存任务资源关系
-	if newTask.AccessType == "28181server" {
-		logger.LOG_WARN("记录任务设备映射关系：", newTask.ID)
-		taskResources[newTask.ID] = newResourceIds
+//下发资源，容器支持时分批下发
+func (w *Worker) assignResource(resources []*model.Resource) error {
+	w.Lock()
+	capability := w.capability
+	w.Unlock()
+	if !capability.Supports(mapi.FEATURE_CHUNKED_ASSIGN) || len(resources) <= capability.AssignChunk {
+		return request(w.manageUrl(mapi.PATH_ASSIGN_RESOURCE), http.MethodPost, "application/json", resources, nil)
+	}
+	total := (len(resources) + capability.AssignChunk - 1) / capability.AssignChunk
+	for i := 0; i < total; i++ {
+		end := (i + 1) * capability.AssignChunk
+		if end > len(resources) {
+			end = len(resources)
+		}
+		header := map[string]string{
+			mapi.HEADER_CHUNK: strconv.Itoa(i+1) + "/" + strconv.Itoa(total),
+		}
+		err := requestWithHeader(w.manageUrl(mapi.PATH_ASSIGN_RESOURCE), http.MethodPost, "application/json", header, resources[i*capability.AssignChunk:end], nil)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-//比对任务
+//任务容器管理接口地址
+func (w *Worker) manageUrl(path string) string {
+	return fmt.Sprintf(_URL_MANAGE, w.td.Runtime.Address(w.TaskId, w.managePort), path)
+}
+
+//比对任务，接入参数按json语义比较
 func compareTask(a, b *model.Task) bool {
-	return a.AccessType == b.AccessType && a.AccessParam == b.AccessParam
+	return a.AccessType == b.AccessType && schema.Equal(a.AccessParam, b.AccessParam)
 }
 
 //比对资源
@@ -329,20 +373,34 @@ func (w *Worker) keepaliveTask() {
 		var wt *model.Task
 		w.Lock()
 		wt = w.workingTask
+		taskInited := w.taskInited
 		w.Unlock()
 		if wt == nil {
 			continue
 		}
-		if !w.taskInited {
+		if !taskInited {
 			continue
 		}
 		//keep alive
-		err := request(fmt.Sprintf(_URL_HEART, TASK_CONTAINER_PREFIX+wt.ID, strconv.Itoa(w.managePort)), http.MethodPost, "application/json", map[string]interface{}{}, nil)
+		var msg jsoniter.RawMessage
+		err := request(w.manageUrl(mapi.PATH_HEART), http.MethodPost, "application/json", struct{}{}, &msg)
+		w.Lock()
+		capability := w.capability
+		w.Unlock()
+		if err == nil && capability.Supports(mapi.FEATURE_HEALTH_DETAIL) {
+			health := &mapi.HeartResponse{}
+			if jsoniter.Unmarshal(msg, health) == nil {
+				w.td.setTaskHealth(w.TaskId, health)
+			}
+		}
 		if err != nil {
 			logger.LOG_WARN("任务keep-alive异常，", err)
 			logger.LOG_WARN("关闭任务:", w.TaskId)
+			w.td.routes.SetHealth(w.TaskId, false)
 			w.stopTask()
+			continue
 		}
+		w.td.routes.SetHealth(w.TaskId, true)
 	}
 }
 
@@ -359,22 +417,30 @@ func (w *Worker) startTask(task *model.Task) {
 		return
 	}
 	logger.LOG_WARN("启动进程：", task.Name)
-	//stop container
-	cmdRes, err := util.ExecCmd(fmt.Sprintf("docker stop %s", TASK_CONTAINER_PREFIX+task.ID))
-	if err != nil {
-		logger.LOG_WARN("关闭容器异常：", err)
-	} else {
-		logger.LOG_WARN("关闭容器成功：", cmdRes)
-	}
-	time.Sleep(5 * time.Second)
-	//create container
 	img := task.Repository
 	if task.CurrentTag != "" {
 		img += ":" + task.CurrentTag
 	}
 	taskDir := TASK_CONTAINER_PREFIX + task.ID
-	var cmd bytes.Buffer
-	cmd.WriteString("docker run --rm -d ")
+	centerHost, centerPort := proxy.Centers().Host()
+	spec := &ContainerSpec{
+		TaskID:  task.ID,
+		Name:    taskDir,
+		Image:   img,
+		Network: "app",
+		Env: map[string]string{
+			"GALAXY_IP":   viper.GetString("center.host"),
+			"GALAXY_HOST": galaxyHost(),
+			"GALAXY_PORT": viper.GetString("port"),
+			"MANAGE_PORT": strconv.Itoa(w.managePort),
+			"HOST":        viper.GetString("host"),
+			"LOG_LEVEL":   viper.GetString("log.level"),
+			"CENTER_IP":   centerHost,
+			"CENTER_PORT": centerPort,
+		},
+		Volumes:    []string{"/home/dyzs/logs/" + taskDir + ":/logs"},
+		ManagePort: w.managePort,
+	}
 	//ports
 	if len(task.ExportPorts) > 0 {
 		eps := make([]string, 0)
@@ -384,58 +450,58 @@ func (w *Worker) startTask(task *model.Task) {
 			return
 		}
 		for _, p := range eps {
-			_, err := strconv.Atoi(strings.Trim(p, " "))
+			port, err := strconv.Atoi(strings.Trim(p, " "))
 			if err == nil {
-				cmd.WriteString(" -p " + p + ":" + p + " -p " + p + ":" + p + "/udp ")
+				spec.Ports = append(spec.Ports, port)
 			}
 		}
 	}
-	//network
-	cmd.WriteString(" --network app ")
-	//name
-	cmd.WriteString(" --name=" + taskDir)
-	//env
-	cmd.WriteString(" -e GALAXY_IP=" + viper.GetString("center.host") + " ")
-	cmd.WriteString(" -e GALAXY_PORT=" + viper.GetString("port") + " ")
-	cmd.WriteString(" -e MANAGE_PORT=" + strconv.Itoa(w.managePort) + " ")
-	cmd.WriteString(" -e HOST=" + viper.GetString("host") + " ")
-	cmd.WriteString(" -e LOG_LEVEL=" + viper.GetString("log.level") + " ")
-	cmd.WriteString(" -e CENTER_IP=" + viper.GetString("center.host") + " ")
-	cmd.WriteString(" -e CENTER_PORT=" + viper.GetString("center.managePort") + " ")
-	//volume
-	cmd.WriteString(" -v /home/dyzs/logs/" + taskDir + ":/logs ")
-	//image
-	cmd.WriteString(img)
-
-	cmdRes, err = util.ExecCmd(cmd.String())
+	err := w.td.Runtime.Start(spec)
 	if err != nil {
 		logger.LOG_WARN("启动容器异常：", err)
 		return
 	}
-	logger.LOG_WARN("启动容器成功：", cmdRes)
 	w.Lock()
 	w.workingTask = task
 	w.Unlock()
 }
 
+//容器访问galaxy的地址，默认为盒子地址；GALAXY_IP沿用旧含义（中心地址）
+func galaxyHost() string {
+	if host := viper.GetString("galaxyHost"); host != "" {
+		return host
+	}
+	return viper.GetString("host")
+}
+
 //停止任务
 func (w *Worker) stopTask() {
-	//stop container
-	cmdRes, err := util.ExecCmd(fmt.Sprintf("docker stop %s", TASK_CONTAINER_PREFIX+w.TaskId))
-	if err != nil {
-		logger.LOG_WARN("关闭容器异常：", err)
-	} else {
-		logger.LOG_WARN("关闭容器成功：", cmdRes)
-	}
-	_, _ = util.ExecCmd(fmt.Sprintf("docker rm %s", TASK_CONTAINER_PREFIX+w.TaskId))
+	_ = w.td.Runtime.Stop(w.TaskId)
+	w.td.routes.SetHealth(w.TaskId, false)
 	w.Lock()
 	w.workingTask = nil
 	w.Unlock()
 }
 
+//获取指定管理端口，已被占用时分配新端口
+func getManagePort(port int) int {
+	if port > 0 {
+		managePortPoolLock.Lock()
+		used := managePortPool[port]
+		if !used {
+			managePortPool[port] = true
+		}
+		managePortPoolLock.Unlock()
+		if !used {
+			return port
+		}
+	}
+	return getNewManagePort()
+}
+
 func getNewManagePort() (port int) {
 	managePortPoolLock.Lock()
-	managePortPoolLock.Unlock()
+	defer managePortPoolLock.Unlock()
 	for i := managePortStart; i < 65535; i++ {
 		if used, _ := managePortPool[i]; !used {
 			managePortPool[i] = true
@@ -447,7 +513,7 @@ func getNewManagePort() (port int) {
 
 func revokeManagePort(port int) {
 	managePortPoolLock.Lock()
-	managePortPoolLock.Unlock()
+	defer managePortPoolLock.Unlock()
 	delete(managePortPool, port)
 }
 
@@ -463,6 +529,11 @@ var workerHttpClient = &http.Client{
 
 //http请求
 func request(url, method, contentType string, body interface{}, resPointer interface{}) error {
+	return requestWithHeader(url, method, contentType, nil, body, resPointer)
+}
+
+//http请求，附带请求头
+func requestWithHeader(url, method, contentType string, header map[string]string, body interface{}, resPointer interface{}) error {
 	var bodyBytes []byte
 	var resBytes []byte
 	if body != nil {
@@ -483,6 +554,9 @@ func request(url, method, contentType string, body interface{}, resPointer inter
 			return err
 		}
 		req.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
 		res, err := workerHttpClient.Do(req)
 		if err != nil {
 			return err
@@ -506,7 +580,7 @@ func request(url, method, contentType string, body interface{}, resPointer inter
 	if err != nil {
 		return err
 	}
-	res := &ResponseWrap{}
+	res := &mapi.ResponseWrap{}
 	err = jsoniter.Unmarshal(resBytes, res)
 	if err != nil {
 		return err
@@ -514,13 +588,69 @@ func request(url, method, contentType string, body interface{}, resPointer inter
 	if res.Code != http.StatusOK {
 		return errors.New("error response code:" + strconv.Itoa(res.Code))
 	}
-	if resPointer != nil {
+	if resPointer != nil && len(res.Msg) > 0 {
 		return jsoniter.Unmarshal(res.Msg, resPointer)
 	}
 	return nil
 }
 
-type ResponseWrap struct {
-	Code int                 `json:"code"`
-	Msg  jsoniter.RawMessage `json:"msg"`
+//执行器状态
+func (w *Worker) status() *WorkerStatus {
+	w.Lock()
+	defer w.Unlock()
+	ws := &WorkerStatus{
+		TaskId:     w.TaskId,
+		ManagePort: w.managePort,
+		Address:    w.td.Runtime.Address(w.TaskId, w.managePort),
+		Running:    w.workingTask != nil,
+		Inited:     w.taskInited,
+		Features:   []string{},
+	}
+	if w.workingTask != nil {
+		ws.Image = w.workingTask.Repository
+		if w.workingTask.CurrentTag != "" {
+			ws.Image += ":" + w.workingTask.CurrentTag
+		}
+		ws.ResourceId = w.workingTask.ResourceId
+		if w.taskInited {
+			ws.Resources = len(w.workingTask.GetResources())
+		}
+	}
+	if w.capability != nil {
+		ws.ApiVersion = w.capability.ApiVersion
+		if w.capability.Features != nil {
+			ws.Features = w.capability.Features
+		}
+	}
+	return ws
+}
+
+//停止容器，由bindTask重新创建
+func (w *Worker) restart() {
+	logger.LOG_WARN("手动重启任务：", w.TaskId)
+	w.Lock()
+	w.taskInited = false
+	w.Unlock()
+	w.stopTask()
+}
+
+//标记未初始化，由bindTask重新初始化
+func (w *Worker) reinit() {
+	logger.LOG_WARN("手动重新初始化任务：", w.TaskId)
+	w.Lock()
+	w.taskInited = false
+	w.Unlock()
+}
+
+//全量重新下发当前资源
+func (w *Worker) resync() error {
+	w.Lock()
+	wt := w.workingTask
+	inited := w.taskInited
+	w.Unlock()
+	if wt == nil || !inited {
+		return ErrTaskNotInited
+	}
+	logger.LOG_WARN("手动重新下发资源：", w.TaskId)
+	return w.refreshResource(nil, wt)
 }
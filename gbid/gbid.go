@@ -0,0 +1,228 @@
+package gbid
+
+import (
+	"errors"
+	"strconv"
+)
+
+//GB/T 28181 统一编码：中心编码(8) + 行业编码(2) + 类型编码(3) + 网络标识(1) + 序号(6)
+const ID_LENGTH = 20
+
+var ErrLength = errors.New("国标编码长度应为20位")
+var ErrNotDigit = errors.New("国标编码只能包含数字")
+var ErrCivilCode = errors.New("国标编码行政区划不合法")
+var ErrTypeCode = errors.New("国标编码类型编码不合法")
+
+//类型分类
+const (
+	CATEGORY_UNKNOWN    = "unknown"
+	CATEGORY_DEVICE     = "device"     //前端主设备 111-130
+	CATEGORY_PERIPHERAL = "peripheral" //前端外围设备 131-199
+	CATEGORY_PLATFORM   = "platform"   //平台设备 200-299
+	CATEGORY_GROUP      = "group"      //业务分组/虚拟组织 215/216
+	CATEGORY_USER       = "user"       //中心用户/终端用户 300-499
+	CATEGORY_EXTENSION  = "extension"  //扩展类型 500-999
+)
+
+//类型编码
+const (
+	TYPE_DVR              = 111
+	TYPE_VIDEO_SERVER     = 112
+	TYPE_ENCODER          = 113
+	TYPE_DECODER          = 114
+	TYPE_VIDEO_SWITCH     = 115
+	TYPE_AUDIO_SWITCH     = 116
+	TYPE_ALARM_CONTROLLER = 117
+	TYPE_NVR              = 118
+	TYPE_HVR              = 119
+	TYPE_CAMERA           = 131
+	TYPE_IPC              = 132
+	TYPE_DISPLAY          = 133
+	TYPE_ALARM_INPUT      = 134
+	TYPE_ALARM_OUTPUT     = 135
+	TYPE_VOICE_INPUT      = 136
+	TYPE_VOICE_OUTPUT     = 137
+	TYPE_MOBILE_TRANSMIT  = 138
+	TYPE_PERIPHERAL_OTHER = 139
+	TYPE_CENTER_SERVER    = 200
+	TYPE_WEB_SERVER       = 201
+	TYPE_MEDIA_SERVER     = 202
+	TYPE_PROXY_SERVER     = 203
+	TYPE_SECURITY_SERVER  = 204
+	TYPE_ALARM_SERVER     = 205
+	TYPE_DATABASE_SERVER  = 206
+	TYPE_GIS_SERVER       = 207
+	TYPE_MANAGE_SERVER    = 208
+	TYPE_ACCESS_GATEWAY   = 209
+	TYPE_STORAGE_SERVER   = 210
+	TYPE_SIGNAL_GATEWAY   = 211
+	TYPE_SERVICE_GATEWAY  = 212
+	TYPE_BUSINESS_GROUP   = 215
+	TYPE_VIRTUAL_ORG      = 216
+	TYPE_CENTER_USER      = 300
+	TYPE_TERMINAL_USER    = 400
+)
+
+var typeNames = map[int]string{
+	TYPE_DVR:              "DVR",
+	TYPE_VIDEO_SERVER:     "视频服务器",
+	TYPE_ENCODER:          "编码器",
+	TYPE_DECODER:          "解码器",
+	TYPE_VIDEO_SWITCH:     "视频切换矩阵",
+	TYPE_AUDIO_SWITCH:     "音频切换矩阵",
+	TYPE_ALARM_CONTROLLER: "报警控制器",
+	TYPE_NVR:              "NVR",
+	TYPE_HVR:              "混合硬盘录像机",
+	TYPE_CAMERA:           "摄像机",
+	TYPE_IPC:              "网络摄像机",
+	TYPE_DISPLAY:          "显示器",
+	TYPE_ALARM_INPUT:      "报警输入设备",
+	TYPE_ALARM_OUTPUT:     "报警输出设备",
+	TYPE_VOICE_INPUT:      "语音输入设备",
+	TYPE_VOICE_OUTPUT:     "语音输出设备",
+	TYPE_MOBILE_TRANSMIT:  "移动传输设备",
+	TYPE_PERIPHERAL_OTHER: "其他外围设备",
+	TYPE_CENTER_SERVER:    "中心信令控制服务器",
+	TYPE_WEB_SERVER:       "Web应用服务器",
+	TYPE_MEDIA_SERVER:     "媒体分发服务器",
+	TYPE_PROXY_SERVER:     "代理服务器",
+	TYPE_SECURITY_SERVER:  "安全服务器",
+	TYPE_ALARM_SERVER:     "报警服务器",
+	TYPE_DATABASE_SERVER:  "数据库服务器",
+	TYPE_GIS_SERVER:       "GIS服务器",
+	TYPE_MANAGE_SERVER:    "管理服务器",
+	TYPE_ACCESS_GATEWAY:   "接入网关",
+	TYPE_STORAGE_SERVER:   "媒体存储服务器",
+	TYPE_SIGNAL_GATEWAY:   "信令安全路由网关",
+	TYPE_SERVICE_GATEWAY:  "业务网关",
+	TYPE_BUSINESS_GROUP:   "业务分组",
+	TYPE_VIRTUAL_ORG:      "虚拟组织",
+	TYPE_CENTER_USER:      "中心用户",
+	TYPE_TERMINAL_USER:    "终端用户",
+}
+
+//解析后的国标编码
+type GbID struct {
+	ID           string `json:"id"`
+	CivilCode    string `json:"civilCode"`    //中心编码（行政区划+基层接入单位）
+	IndustryCode string `json:"industryCode"` //行业编码
+	TypeCode     int    `json:"typeCode"`     //类型编码
+	NetworkCode  int    `json:"networkCode"`  //网络标识
+	Serial       string `json:"serial"`       //设备序号
+}
+
+//解析国标编码
+func Parse(id string) (*GbID, error) {
+	if len(id) != ID_LENGTH {
+		return nil, ErrLength
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return nil, ErrNotDigit
+		}
+	}
+	if id[0:2] == "00" {
+		return nil, ErrCivilCode
+	}
+	typeCode, _ := strconv.Atoi(id[10:13])
+	if typeCode < 111 || typeCode > 999 {
+		return nil, ErrTypeCode
+	}
+	networkCode, _ := strconv.Atoi(id[13:14])
+	return &GbID{
+		ID:           id,
+		CivilCode:    id[0:8],
+		IndustryCode: id[8:10],
+		TypeCode:     typeCode,
+		NetworkCode:  networkCode,
+		Serial:       id[14:20],
+	}, nil
+}
+
+//校验国标编码
+func Validate(id string) error {
+	_, err := Parse(id)
+	return err
+}
+
+//是否合法国标编码
+func Valid(id string) bool {
+	return Validate(id) == nil
+}
+
+//是否行政区划编码（2/4/6/8位）
+func IsCivilCode(code string) bool {
+	switch len(code) {
+	case 2, 4, 6, 8:
+	default:
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//省级行政区划
+func (g *GbID) Province() string {
+	return g.CivilCode[0:2]
+}
+
+//市级行政区划
+func (g *GbID) City() string {
+	return g.CivilCode[0:4]
+}
+
+//区县级行政区划
+func (g *GbID) County() string {
+	return g.CivilCode[0:6]
+}
+
+//类型分类
+func (g *GbID) Category() string {
+	return Category(g.TypeCode)
+}
+
+//类型名称
+func (g *GbID) TypeName() string {
+	if name, ok := typeNames[g.TypeCode]; ok {
+		return name
+	}
+	return "未知类型" + strconv.Itoa(g.TypeCode)
+}
+
+//是否可以出流的视频通道
+func (g *GbID) IsVideoChannel() bool {
+	return g.TypeCode == TYPE_CAMERA || g.TypeCode == TYPE_IPC
+}
+
+//是否前端主设备（DVR/NVR等）
+func (g *GbID) IsDevice() bool {
+	return Category(g.TypeCode) == CATEGORY_DEVICE
+}
+
+//是否业务分组/虚拟组织
+func (g *GbID) IsGroup() bool {
+	return g.TypeCode == TYPE_BUSINESS_GROUP || g.TypeCode == TYPE_VIRTUAL_ORG
+}
+
+//类型编码分类
+func Category(typeCode int) string {
+	switch {
+	case typeCode == TYPE_BUSINESS_GROUP || typeCode == TYPE_VIRTUAL_ORG:
+		return CATEGORY_GROUP
+	case typeCode >= 111 && typeCode <= 130:
+		return CATEGORY_DEVICE
+	case typeCode >= 131 && typeCode <= 199:
+		return CATEGORY_PERIPHERAL
+	case typeCode >= 200 && typeCode <= 299:
+		return CATEGORY_PLATFORM
+	case typeCode >= 300 && typeCode <= 499:
+		return CATEGORY_USER
+	case typeCode >= 500 && typeCode <= 999:
+		return CATEGORY_EXTENSION
+	}
+	return CATEGORY_UNKNOWN
+}
@@ -0,0 +1,96 @@
+package gbid
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name string
+		id   string
+		err  error
+	}{
+		{"合法摄像机", "34020000001320000001", nil},
+		{"19位", "3402000000132000000", ErrLength},
+		{"21位", "340200000013200000011", ErrLength},
+		{"空", "", ErrLength},
+		{"包含字母", "3402000000132000000A", ErrNotDigit},
+		{"包含空格", "3402000000 320000001", ErrNotDigit},
+		{"行政区划00", "00020000001320000001", ErrCivilCode},
+		{"行政区划01", "01020000001320000001", nil},
+		{"类型编码110", "34020000001100000001", ErrTypeCode},
+		{"类型编码111", "34020000001110000001", nil},
+		{"类型编码499", "34020000004990000001", nil},
+		{"类型编码500", "34020000005000000001", nil},
+		{"类型编码999", "34020000009990000001", nil},
+		{"类型编码000", "34020000000000000001", ErrTypeCode},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.id)
+		if err != c.err {
+			t.Errorf("%s: Parse(%q) = %v, want %v", c.name, c.id, err, c.err)
+		}
+		if Valid(c.id) != (c.err == nil) {
+			t.Errorf("%s: Valid(%q) = %v", c.name, c.id, !(c.err == nil))
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	g, err := Parse("34020000001320000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.CivilCode != "34020000" || g.IndustryCode != "00" || g.TypeCode != TYPE_IPC || g.NetworkCode != 0 || g.Serial != "000001" {
+		t.Fatalf("字段解析错误：%+v", g)
+	}
+	if g.Province() != "34" || g.City() != "3402" || g.County() != "340200" {
+		t.Fatalf("行政区划解析错误：%+v", g)
+	}
+	if !g.IsVideoChannel() || g.Category() != CATEGORY_PERIPHERAL {
+		t.Fatalf("类型判断错误：%+v", g)
+	}
+}
+
+func TestCategory(t *testing.T) {
+	cases := []struct {
+		typeCode int
+		category string
+	}{
+		{110, CATEGORY_UNKNOWN},
+		{111, CATEGORY_DEVICE},
+		{130, CATEGORY_DEVICE},
+		{131, CATEGORY_PERIPHERAL},
+		{199, CATEGORY_PERIPHERAL},
+		{200, CATEGORY_PLATFORM},
+		{215, CATEGORY_GROUP},
+		{216, CATEGORY_GROUP},
+		{299, CATEGORY_PLATFORM},
+		{300, CATEGORY_USER},
+		{499, CATEGORY_USER},
+		{500, CATEGORY_EXTENSION},
+		{999, CATEGORY_EXTENSION},
+		{1000, CATEGORY_UNKNOWN},
+	}
+	for _, c := range cases {
+		if got := Category(c.typeCode); got != c.category {
+			t.Errorf("Category(%d) = %s, want %s", c.typeCode, got, c.category)
+		}
+	}
+}
+
+func TestIsCivilCode(t *testing.T) {
+	cases := map[string]bool{
+		"":          false,
+		"3":         false,
+		"34":        true,
+		"3402":      true,
+		"340200":    true,
+		"34020000":  true,
+		"340200000": false,
+		"34a2":      false,
+	}
+	for code, want := range cases {
+		if got := IsCivilCode(code); got != want {
+			t.Errorf("IsCivilCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -37,14 +37,14 @@ func main() {
 
 	logger.Init()
 
-	//初始化配置服务
-	go server.InitCofnigHttpServer()
-
 	//初始化调度服务
 	td := &dispatcher.TaskDispatcher{
 		Host: viper.GetString("host"),
 	}
-	go td.Init()
+	td.Init()
+
+	//初始化配置服务
+	go server.InitCofnigHttpServer(td)
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
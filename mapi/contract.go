@@ -0,0 +1,156 @@
+//galaxy与任务容器之间的管理接口约定
+//
+//galaxy通过任务容器的MANAGE_PORT调用以下接口，请求体均为json，响应统一为ResponseWrap，code为200表示成功：
+//  POST /mapi/init           初始化/更新任务配置，请求体为model.Task（不含资源），响应msg为InitResponse
+//  POST /mapi/heart          保活，响应msg在协商了healthDetail特性时为HeartResponse
+//  POST /mapi/assignResource 下发资源，请求体为[]model.Resource，协商了chunkedAssign特性时分批下发
+//  POST /mapi/revokeResource 回收资源，请求体为资源ID数组
+//
+//版本协商：galaxy在init请求头中携带自身支持的版本和特性，容器在InitResponse中返回其支持的版本和特性，
+//双方取交集。未返回InitResponse的旧版容器视为VERSION_1，不启用任何特性。
+package mapi
+
+import (
+	jsoniter "github.com/json-iterator/go"
+	"strconv"
+	"strings"
+)
+
+const (
+	PATH_INIT            = "/mapi/init"
+	PATH_HEART           = "/mapi/heart"
+	PATH_ASSIGN_RESOURCE = "/mapi/assignResource"
+	PATH_REVOKE_RESOURCE = "/mapi/revokeResource"
+	PATH_CMD             = "/cmd"
+)
+
+//接口版本
+const (
+	VERSION_1 = 1 //初始版本，无协商
+	VERSION_2 = 2 //支持能力协商
+	VERSION   = VERSION_2
+)
+
+//请求头
+const (
+	HEADER_API_VERSION = "X-Galaxy-Api-Version"
+	HEADER_FEATURES    = "X-Galaxy-Features"
+	HEADER_CHUNK       = "X-Galaxy-Chunk" //分批下发时的批次，格式：序号/总数，序号从1开始
+)
+
+//可协商的特性
+const (
+	FEATURE_CHUNKED_ASSIGN = "chunkedAssign" //资源分批下发
+	FEATURE_HEALTH_DETAIL  = "healthDetail"  //心跳返回健康详情
+	FEATURE_ROUTE_DECLARE  = "routeDeclare"  //init响应声明路由范围
+)
+
+//galaxy支持的特性
+var FEATURES = []string{FEATURE_CHUNKED_ASSIGN, FEATURE_HEALTH_DETAIL, FEATURE_ROUTE_DECLARE}
+
+const DEFAULT_ASSIGN_CHUNK = 500
+
+//统一响应
+type ResponseWrap struct {
+	Code int                 `json:"code"`
+	Msg  jsoniter.RawMessage `json:"msg"`
+}
+
+//init响应
+type InitResponse struct {
+	ApiVersion  int      `json:"apiVersion"`
+	Features    []string `json:"features"`
+	AssignChunk int      `json:"assignChunk"` //分批下发时每批资源数
+	RouteKeys   []string `json:"routeKeys"`   //按哪些资源编号路由到该任务
+	RouteIds    []string `json:"routeIds"`    //额外服务的编号
+}
+
+//healthDetail特性下的心跳响应
+type HeartResponse struct {
+	Status    string                 `json:"status"`
+	Message   string                 `json:"message"`
+	Resources int                    `json:"resources"` //已接入资源数
+	Detail    map[string]interface{} `json:"detail"`
+}
+
+//协商结果
+type Capability struct {
+	ApiVersion  int      `json:"apiVersion"`
+	Features    []string `json:"features"`
+	AssignChunk int      `json:"assignChunk"`
+}
+
+//旧版容器的能力
+var LEGACY = &Capability{ApiVersion: VERSION_1}
+
+//解析init响应，msg不是InitResponse时视为旧版容器
+func ParseInitResponse(msg jsoniter.RawMessage) *InitResponse {
+	if len(msg) == 0 {
+		return nil
+	}
+	res := &InitResponse{}
+	if jsoniter.Unmarshal(msg, res) != nil || res.ApiVersion < VERSION_2 {
+		return nil
+	}
+	return res
+}
+
+//按init响应协商能力
+func Negotiate(res *InitResponse) *Capability {
+	if res == nil {
+		return LEGACY
+	}
+	c := &Capability{
+		ApiVersion: res.ApiVersion,
+	}
+	if c.ApiVersion > VERSION {
+		c.ApiVersion = VERSION
+	}
+	for _, f := range res.Features {
+		for _, supported := range FEATURES {
+			if f == supported {
+				c.Features = append(c.Features, f)
+			}
+		}
+	}
+	if c.Supports(FEATURE_CHUNKED_ASSIGN) {
+		c.AssignChunk = res.AssignChunk
+		if c.AssignChunk <= 0 {
+			c.AssignChunk = DEFAULT_ASSIGN_CHUNK
+		}
+	}
+	return c
+}
+
+//是否支持特性
+func (c *Capability) Supports(feature string) bool {
+	if c == nil {
+		return false
+	}
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+//galaxy发起init时携带的请求头
+func InitHeaders() map[string]string {
+	return map[string]string{
+		HEADER_API_VERSION: strconv.Itoa(VERSION),
+		HEADER_FEATURES:    strings.Join(FEATURES, ","),
+	}
+}
+
+//解析请求头中的特性列表
+func ParseFeatures(header string) []string {
+	var features []string
+	for _, f := range strings.Split(header, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			features = append(features, f)
+		}
+	}
+	return features
+}
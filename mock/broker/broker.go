@@ -0,0 +1,314 @@
+//内嵌MQTT broker，支持MQTT 3.1.1的QoS0/1、保留消息、通配符订阅和遗嘱，用于测试MQTT中心代理
+package broker
+
+import (
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"net"
+	"strings"
+	"sync"
+)
+
+//收到的发布消息
+type Message struct {
+	ClientID string
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+type client struct {
+	sync.Mutex
+	id     string
+	conn   net.Conn
+	subs   map[string]byte
+	will   *packets.PublishPacket
+	msgId  uint16
+	closed bool
+}
+
+func (c *client) write(p packets.ControlPacket) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return p.Write(c.conn)
+}
+
+func (c *client) nextId() uint16 {
+	c.Lock()
+	defer c.Unlock()
+	c.msgId++
+	if c.msgId == 0 {
+		c.msgId = 1
+	}
+	return c.msgId
+}
+
+type Broker struct {
+	sync.Mutex
+	listener  net.Listener
+	clients   map[string]*client
+	retained  map[string]*packets.PublishPacket
+	published []*Message
+	handlers  []func(*Message)
+}
+
+//在127.0.0.1随机端口启动
+func Start() (*Broker, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		listener: l,
+		clients:  make(map[string]*client),
+		retained: make(map[string]*packets.PublishPacket),
+	}
+	go b.accept()
+	return b, nil
+}
+
+//连接地址，如 tcp://127.0.0.1:1883
+func (b *Broker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *Broker) Close() {
+	_ = b.listener.Close()
+	b.Lock()
+	for _, c := range b.clients {
+		_ = c.conn.Close()
+	}
+	b.Unlock()
+}
+
+//发布消息（模拟中心）
+func (b *Broker) Publish(topic string, payload []byte, retain bool) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = payload
+	p.Retain = retain
+	p.Qos = 1
+	b.route("", p)
+}
+
+//订阅收到的消息（模拟中心），handler在broker协程中调用
+func (b *Broker) OnPublish(handler func(*Message)) {
+	b.Lock()
+	b.handlers = append(b.handlers, handler)
+	b.Unlock()
+}
+
+//客户端发布过的消息
+func (b *Broker) Published() []*Message {
+	b.Lock()
+	defer b.Unlock()
+	return append([]*Message(nil), b.published...)
+}
+
+//模拟客户端异常断开，触发遗嘱
+func (b *Broker) Disconnect(clientId string) {
+	b.Lock()
+	c := b.clients[clientId]
+	b.Unlock()
+	if c != nil {
+		_ = c.conn.Close()
+	}
+}
+
+func (b *Broker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.serve(conn)
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	p, err := packets.ReadPacket(conn)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	cp, ok := p.(*packets.ConnectPacket)
+	if !ok {
+		_ = conn.Close()
+		return
+	}
+	c := &client{id: cp.ClientIdentifier, conn: conn, subs: make(map[string]byte)}
+	if cp.WillFlag {
+		will := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		will.TopicName = cp.WillTopic
+		will.Payload = cp.WillMessage
+		will.Retain = cp.WillRetain
+		will.Qos = cp.WillQos
+		c.will = will
+	}
+	b.Lock()
+	if old, ok := b.clients[c.id]; ok {
+		old.will = nil
+		_ = old.conn.Close()
+	}
+	b.clients[c.id] = c
+	b.Unlock()
+	ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	if c.write(ack) != nil {
+		return
+	}
+	graceful := false
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			break
+		}
+		switch pk := p.(type) {
+		case *packets.PublishPacket:
+			if pk.Qos > 0 {
+				pa := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				pa.MessageID = pk.MessageID
+				_ = c.write(pa)
+			}
+			b.route(c.id, pk)
+		case *packets.SubscribePacket:
+			sa := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			sa.MessageID = pk.MessageID
+			for i, topic := range pk.Topics {
+				qos := pk.Qoss[i]
+				if qos > 1 {
+					qos = 1
+				}
+				c.Lock()
+				c.subs[topic] = qos
+				c.Unlock()
+				sa.ReturnCodes = append(sa.ReturnCodes, qos)
+			}
+			_ = c.write(sa)
+			b.sendRetained(c, pk.Topics)
+		case *packets.UnsubscribePacket:
+			c.Lock()
+			for _, topic := range pk.Topics {
+				delete(c.subs, topic)
+			}
+			c.Unlock()
+			ua := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ua.MessageID = pk.MessageID
+			_ = c.write(ua)
+		case *packets.PingreqPacket:
+			_ = c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			graceful = true
+		}
+		if graceful {
+			break
+		}
+	}
+	c.Lock()
+	c.closed = true
+	c.Unlock()
+	_ = conn.Close()
+	b.Lock()
+	if b.clients[c.id] == c {
+		delete(b.clients, c.id)
+	}
+	b.Unlock()
+	if !graceful && c.will != nil {
+		b.route(c.id, c.will)
+	}
+}
+
+//分发消息
+func (b *Broker) route(from string, p *packets.PublishPacket) {
+	b.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			b.retained[p.TopicName] = p
+		}
+	}
+	msg := &Message{ClientID: from, Topic: p.TopicName, Payload: p.Payload, Retained: p.Retain}
+	if from != "" {
+		b.published = append(b.published, msg)
+	}
+	handlers := append([]func(*Message){}, b.handlers...)
+	clients := make([]*client, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.Unlock()
+	if from != "" {
+		for _, h := range handlers {
+			h(msg)
+		}
+	}
+	for _, c := range clients {
+		c.Lock()
+		var matched bool
+		var qos byte
+		for filter, q := range c.subs {
+			if Match(filter, p.TopicName) {
+				matched = true
+				if q > qos {
+					qos = q
+				}
+			}
+		}
+		c.Unlock()
+		if matched {
+			b.deliver(c, p, qos, false)
+		}
+	}
+}
+
+func (b *Broker) sendRetained(c *client, filters []string) {
+	b.Lock()
+	var matched []*packets.PublishPacket
+	for topic, p := range b.retained {
+		for _, f := range filters {
+			if Match(f, topic) {
+				matched = append(matched, p)
+				break
+			}
+		}
+	}
+	b.Unlock()
+	for _, p := range matched {
+		b.deliver(c, p, 1, true)
+	}
+}
+
+func (b *Broker) deliver(c *client, src *packets.PublishPacket, qos byte, retain bool) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = src.TopicName
+	p.Payload = src.Payload
+	p.Retain = retain
+	if src.Qos < qos {
+		qos = src.Qos
+	}
+	p.Qos = qos
+	if qos > 0 {
+		p.MessageID = c.nextId()
+	}
+	_ = c.write(p)
+}
+
+//主题过滤器匹配，支持+和#
+func Match(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
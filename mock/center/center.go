@@ -0,0 +1,443 @@
+//模拟中心，基于httptest，支持脚本化任务/资源、故障注入、记录心跳和通道上报，以及预览websocket
+package center
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/tls"
+	"crypto/x509"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/proxy"
+	"dyzs/galaxy/util/uuid"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PATH_HEART          = "/management/box/heart"
+	PATH_RESOURCE       = "/management/task/resource"
+	PATH_SUBMIT_CHANNEL = "/management/sensor/submitChannels"
+	PATH_WS_PREFIX      = "/exchange/ws/"
+	PATH_PROVISION      = "/management/box/provision"
+)
+
+//签名时间戳允许的偏差
+const _SIGN_MAX_SKEW = 5 * time.Minute
+
+//收到的心跳
+type HeartRecord struct {
+	Time  time.Time
+	Query string
+	Body  map[string]interface{}
+}
+
+//收到的通道上报
+type ChannelSubmission struct {
+	Time     time.Time
+	Gid      string                   `json:"gid"`
+	Channels []map[string]interface{} `json:"channels"`
+	Removed  []string                 `json:"removed"`
+}
+
+//注入的故障
+type failure struct {
+	status int
+	code   string //http 200但业务code非200
+	times  int    //剩余次数，<0表示一直失败
+	delay  time.Duration
+}
+
+//模拟中心
+type Center struct {
+	sync.Mutex
+	server *httptest.Server
+
+	nodeId      string
+	tasks       []*model.Task
+	resources   map[string]string
+	failures    map[string]*failure
+	heartbeats  []*HeartRecord
+	submissions []*ChannelSubmission
+	requests    map[string]int
+
+	upgrader  websocket.Upgrader
+	wsConns   map[string]*websocket.Conn
+	wsLock    sync.Mutex
+	wsRecords []*WsMessage
+
+	authEnabled   bool
+	provisionCode string
+	secrets       map[string]string
+	nonces        map[string]time.Time
+	authFailures  int
+}
+
+func New() *Center {
+	c := newCenter()
+	c.server = httptest.NewServer(c.handler())
+	return c
+}
+
+//https中心，clientCAs不为空时要求盒子客户端证书（双向认证）
+func NewTLS(clientCAs *x509.CertPool) *Center {
+	c := newCenter()
+	c.server = httptest.NewUnstartedServer(c.handler())
+	if clientCAs != nil {
+		c.server.TLS = &tls.Config{
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+	c.server.StartTLS()
+	return c
+}
+
+//中心的服务端证书
+func (c *Center) Certificate() *x509.Certificate {
+	return c.server.Certificate()
+}
+
+func newCenter() *Center {
+	return &Center{
+		nodeId:    "box-1",
+		resources: make(map[string]string),
+		failures:  make(map[string]*failure),
+		requests:  make(map[string]int),
+		wsConns:   make(map[string]*websocket.Conn),
+		secrets:   make(map[string]string),
+		nonces:    make(map[string]time.Time),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+	}
+}
+
+func (c *Center) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PATH_HEART, c.heart)
+	mux.HandleFunc(PATH_RESOURCE+"/", c.resource)
+	mux.HandleFunc(PATH_SUBMIT_CHANNEL, c.submitChannel)
+	mux.HandleFunc(PATH_WS_PREFIX, c.ws)
+	mux.HandleFunc(PATH_PROVISION, c.provision)
+	return c.wrap(mux)
+}
+
+//关闭
+func (c *Center) Close() {
+	c.wsLock.Lock()
+	for _, ws := range c.wsConns {
+		_ = ws.Close()
+	}
+	c.wsLock.Unlock()
+	c.server.Close()
+}
+
+func (c *Center) URL() string {
+	return c.server.URL
+}
+
+//监听地址的host和port
+func (c *Center) HostPort() (host, port string) {
+	host, port, _ = net.SplitHostPort(c.server.Listener.Addr().String())
+	return host, port
+}
+
+//将中心地址写入配置
+func (c *Center) ApplyConfig() {
+	host, port := c.HostPort()
+	viper.Set("center.host", host)
+	viper.Set("center.managePort", port)
+	viper.Set("center.url-heart", PATH_HEART)
+	viper.Set("center.url-resource", PATH_RESOURCE)
+	viper.Set("center.tls.enable", c.server.TLS != nil)
+}
+
+//设置盒子ID
+func (c *Center) SetNodeId(id string) {
+	c.Lock()
+	c.nodeId = id
+	c.Unlock()
+}
+
+//设置心跳下发的任务
+func (c *Center) SetTasks(tasks ...*model.Task) {
+	c.Lock()
+	c.tasks = tasks
+	c.Unlock()
+}
+
+//设置资源csv
+func (c *Center) SetResource(resourceId, csv string) {
+	c.Lock()
+	c.resources[resourceId] = csv
+	c.Unlock()
+}
+
+//接口返回status，times次后恢复，times<0时一直失败，status为0时清除故障
+func (c *Center) Fail(path string, status int, times int) {
+	c.Lock()
+	defer c.Unlock()
+	if status == 0 {
+		delete(c.failures, path)
+		return
+	}
+	c.failures[path] = &failure{status: status, times: times}
+}
+
+//接口返回http 200、业务code为code，times次后恢复，times<0时一直失败
+func (c *Center) Reject(path string, code string, times int) {
+	c.Lock()
+	defer c.Unlock()
+	c.failures[path] = &failure{code: code, times: times}
+}
+
+//接口响应延迟
+func (c *Center) Delay(path string, delay time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	f, ok := c.failures[path]
+	if !ok {
+		f = &failure{}
+		c.failures[path] = f
+	}
+	f.delay = delay
+}
+
+//开启盒子认证，provisionCode为注册码，为空时不校验注册码
+func (c *Center) EnableAuth(provisionCode string) {
+	c.Lock()
+	c.authEnabled = true
+	c.provisionCode = provisionCode
+	c.Unlock()
+}
+
+//设置盒子密钥，secret为空时吊销
+func (c *Center) SetSecret(sn, secret string) {
+	c.Lock()
+	defer c.Unlock()
+	if secret == "" {
+		delete(c.secrets, sn)
+		return
+	}
+	c.secrets[sn] = secret
+}
+
+//签名校验失败次数
+func (c *Center) AuthFailures() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.authFailures
+}
+
+//收到的心跳
+func (c *Center) Heartbeats() []*HeartRecord {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*HeartRecord(nil), c.heartbeats...)
+}
+
+//收到的通道上报
+func (c *Center) ChannelSubmissions() []*ChannelSubmission {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*ChannelSubmission(nil), c.submissions...)
+}
+
+//接口请求次数（含失败）
+func (c *Center) Requests(path string) int {
+	c.Lock()
+	defer c.Unlock()
+	return c.requests[path]
+}
+
+//按路径注入故障和计数
+func (c *Center) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, PATH_RESOURCE+"/") {
+			path = PATH_RESOURCE
+		}
+		c.Lock()
+		c.requests[path]++
+		f := c.failures[path]
+		var status int
+		var code string
+		var delay time.Duration
+		if f != nil {
+			delay = f.delay
+			if (f.status != 0 || f.code != "") && f.times != 0 {
+				status, code = f.status, f.code
+				if f.times > 0 {
+					f.times--
+				}
+			}
+		}
+		c.Unlock()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if status != 0 {
+			http.Error(w, "injected failure", status)
+			return
+		}
+		if code != "" {
+			writeCode(w, code, "injected failure", nil)
+			return
+		}
+		if path != PATH_PROVISION {
+			if err := c.verify(r); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (c *Center) heart(w http.ResponseWriter, r *http.Request) {
+	body := make(map[string]interface{})
+	bodyBytes, _ := ioutil.ReadAll(r.Body)
+	_ = json.Unmarshal(bodyBytes, &body)
+	c.Lock()
+	c.heartbeats = append(c.heartbeats, &HeartRecord{
+		Time:  time.Now(),
+		Query: r.URL.RawQuery,
+		Body:  body,
+	})
+	data := map[string]interface{}{
+		"box":   model.Node{Id: c.nodeId},
+		"tasks": c.tasks,
+	}
+	c.Unlock()
+	writeData(w, data)
+}
+
+func (c *Center) resource(w http.ResponseWriter, r *http.Request) {
+	resourceId := strings.TrimPrefix(r.URL.Path, PATH_RESOURCE+"/")
+	c.Lock()
+	csv, ok := c.resources[resourceId]
+	c.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write([]byte(csv))
+}
+
+func (c *Center) submitChannel(w http.ResponseWriter, r *http.Request) {
+	s := &ChannelSubmission{Time: time.Now()}
+	bodyBytes, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(bodyBytes, s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Lock()
+	c.submissions = append(c.submissions, s)
+	c.Unlock()
+	writeData(w, nil)
+}
+
+func (c *Center) provision(w http.ResponseWriter, r *http.Request) {
+	body := &struct {
+		SerialNumber  string `json:"serialNumber"`
+		ProvisionCode string `json:"provisionCode"`
+	}{}
+	bodyBytes, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(bodyBytes, body)
+	if err != nil || body.SerialNumber == "" {
+		http.Error(w, "invalid provision request", http.StatusBadRequest)
+		return
+	}
+	c.Lock()
+	if c.provisionCode != "" && body.ProvisionCode != c.provisionCode {
+		c.Unlock()
+		http.Error(w, "invalid provision code", http.StatusForbidden)
+		return
+	}
+	secret := uuid.UUIDShort()
+	c.secrets[body.SerialNumber] = secret
+	c.Unlock()
+	writeData(w, map[string]interface{}{
+		"serialNumber": body.SerialNumber,
+		"secret":       secret,
+	})
+}
+
+//校验请求签名，未开启认证时不校验
+func (c *Center) verify(r *http.Request) error {
+	c.Lock()
+	defer c.Unlock()
+	if !c.authEnabled {
+		return nil
+	}
+	err := c.checkSignature(r)
+	if err != nil {
+		c.authFailures++
+	}
+	return err
+}
+
+func (c *Center) checkSignature(r *http.Request) error {
+	sn := r.Header.Get(proxy.HEADER_BOX_SN)
+	secret, ok := c.secrets[sn]
+	if !ok {
+		return errors.New("unknown box")
+	}
+	timestamp := r.Header.Get(proxy.HEADER_TIMESTAMP)
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	skew := time.Since(time.Unix(0, ms*1e6))
+	if skew > _SIGN_MAX_SKEW || skew < -_SIGN_MAX_SKEW {
+		return errors.New("timestamp expired")
+	}
+	nonce := r.Header.Get(proxy.HEADER_NONCE)
+	if _, used := c.nonces[nonce]; used || nonce == "" {
+		return errors.New("nonce reused")
+	}
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	expected := proxy.Sign(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(proxy.HEADER_SIGNATURE))) {
+		return errors.New("invalid signature")
+	}
+	for k, t := range c.nonces {
+		if time.Since(t) > _SIGN_MAX_SKEW {
+			delete(c.nonces, k)
+		}
+	}
+	c.nonces[nonce] = time.Now()
+	return nil
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeCode(w, "200", "success", data)
+}
+
+func writeCode(w http.ResponseWriter, code, message string, data interface{}) {
+	dataBytes, _ := json.Marshal(data)
+	resBytes, _ := json.Marshal(map[string]interface{}{
+		"code":    code,
+		"message": message,
+		"data":    json.RawMessage(dataBytes),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(resBytes)
+}
@@ -0,0 +1,138 @@
+package center
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//预览websocket消息，中心下发和盒子回复共用
+type WsMessage struct {
+	Sid              string          `json:"-"`
+	RequestId        string          `json:"requestId"`
+	DayuId           string          `json:"dayuId"`
+	From             string          `json:"from"`
+	To               string          `json:"to"`
+	SendType         string          `json:"sendType"`
+	ContentType      string          `json:"contentType"`
+	InteractiveModel string          `json:"interactiveModel,omitempty"`
+	Content          json.RawMessage `json:"content"`
+	Timestamp        int64           `json:"timestamp"`
+}
+
+var ErrWsNotConnected = errors.New("盒子websocket未连接")
+
+// /exchange/ws/{namespace}/{sid}
+func (c *Center) ws(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, PATH_WS_PREFIX), "/")
+	if len(parts) != 2 || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	sid := parts[1]
+	ws, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c.wsLock.Lock()
+	if old, ok := c.wsConns[sid]; ok {
+		_ = old.Close()
+	}
+	c.wsConns[sid] = ws
+	c.wsLock.Unlock()
+	for {
+		msg := &WsMessage{}
+		err := ws.ReadJSON(msg)
+		if err != nil {
+			break
+		}
+		msg.Sid = sid
+		c.wsLock.Lock()
+		c.wsRecords = append(c.wsRecords, msg)
+		c.wsLock.Unlock()
+	}
+	c.wsLock.Lock()
+	if c.wsConns[sid] == ws {
+		delete(c.wsConns, sid)
+	}
+	c.wsLock.Unlock()
+}
+
+//盒子是否已连接
+func (c *Center) WsConnected(sid string) bool {
+	c.wsLock.Lock()
+	defer c.wsLock.Unlock()
+	_, ok := c.wsConns[sid]
+	return ok
+}
+
+//等待盒子连接
+func (c *Center) WaitWs(sid string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if c.WsConnected(sid) {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+//向盒子下发消息，content为任意可json序列化的内容
+func (c *Center) Push(sid string, msg *WsMessage, content interface{}) error {
+	if content != nil {
+		contentBytes, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		msg.Content = contentBytes
+	}
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixNano() / 1e6
+	}
+	c.wsLock.Lock()
+	defer c.wsLock.Unlock()
+	ws, ok := c.wsConns[sid]
+	if !ok {
+		return ErrWsNotConnected
+	}
+	return ws.WriteJSON(msg)
+}
+
+//断开盒子websocket，用于模拟网络中断
+func (c *Center) DropWs(sid string) {
+	c.wsLock.Lock()
+	defer c.wsLock.Unlock()
+	if ws, ok := c.wsConns[sid]; ok {
+		_ = ws.Close()
+		delete(c.wsConns, sid)
+	}
+}
+
+//收到的盒子消息
+func (c *Center) WsReceived() []*WsMessage {
+	c.wsLock.Lock()
+	defer c.wsLock.Unlock()
+	return append([]*WsMessage(nil), c.wsRecords...)
+}
+
+//通知盒子任务变更，withTasks为true时携带当前任务列表
+func (c *Center) NotifyTasksChanged(sid string, withTasks bool) error {
+	param := map[string]interface{}{}
+	if withTasks {
+		c.Lock()
+		param["tasks"] = c.tasks
+		c.Unlock()
+	}
+	return c.Push(sid, &WsMessage{
+		RequestId:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		SendType:    "sensorMultiple",
+		ContentType: "application/json",
+	}, map[string]interface{}{
+		"Cmd":   "TasksChanged",
+		"Param": param,
+	})
+}
@@ -0,0 +1,175 @@
+//模拟任务组件，实现/mapi/*和/cmd，行为可脚本化，用于本地端到端测试调度器
+package component
+
+import (
+	"dyzs/galaxy/mapi"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/sdk"
+	"errors"
+	"sync"
+	"time"
+)
+
+//可脚本化的行为
+type Behavior struct {
+	InitDelay       time.Duration   //init响应延迟
+	InitError       string          //不为空时init返回该错误
+	HeartFailAfter  int             //第几次心跳之后开始失败，0表示不失败
+	RejectAll       bool            //拒绝所有资源下发
+	RejectResources map[string]bool //拒绝下发的资源ID
+	EchoCommands    bool            ///cmd原样返回指令参数
+	RouteKeys       []string        //声明的路由键类型
+	RouteIds        []string        //声明的额外路由编号
+}
+
+//收到的指令
+type ReceivedCommand struct {
+	Cmd  *sdk.Command
+	Time time.Time
+}
+
+//模拟组件，记录收到的全部管理请求
+type Component struct {
+	sync.Mutex
+	behavior   Behavior
+	task       *model.Task
+	resources  map[string]*model.Resource
+	initCount  int
+	heartCount int
+	commands   []*ReceivedCommand
+}
+
+func New(behavior Behavior) *Component {
+	return &Component{
+		behavior:  behavior,
+		resources: make(map[string]*model.Resource),
+	}
+}
+
+//运行中修改行为
+func (c *Component) SetBehavior(behavior Behavior) {
+	c.Lock()
+	c.behavior = behavior
+	c.Unlock()
+}
+
+func (c *Component) Behavior() Behavior {
+	c.Lock()
+	defer c.Unlock()
+	return c.behavior
+}
+
+func (c *Component) Init(task *model.Task) error {
+	b := c.Behavior()
+	if b.InitDelay > 0 {
+		time.Sleep(b.InitDelay)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.initCount++
+	if b.InitError != "" {
+		return errors.New(b.InitError)
+	}
+	c.task = task
+	return nil
+}
+
+func (c *Component) Assign(resources []*model.Resource) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, r := range resources {
+		if c.behavior.RejectAll || c.behavior.RejectResources[r.ID] {
+			return errors.New("拒绝资源：" + r.ID)
+		}
+	}
+	for _, r := range resources {
+		c.resources[r.ID] = r
+	}
+	return nil
+}
+
+func (c *Component) Revoke(resourceIds []string) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, id := range resourceIds {
+		delete(c.resources, id)
+	}
+	return nil
+}
+
+func (c *Component) Heart() error {
+	c.Lock()
+	defer c.Unlock()
+	c.heartCount++
+	if c.behavior.HeartFailAfter > 0 && c.heartCount > c.behavior.HeartFailAfter {
+		return errors.New("模拟心跳失败")
+	}
+	return nil
+}
+
+func (c *Component) Health() *mapi.HeartResponse {
+	c.Lock()
+	defer c.Unlock()
+	return &mapi.HeartResponse{
+		Status:    "ok",
+		Resources: len(c.resources),
+	}
+}
+
+func (c *Component) Routes() (keys []string, ids []string) {
+	b := c.Behavior()
+	return b.RouteKeys, b.RouteIds
+}
+
+func (c *Component) HandleCmd(cmd *sdk.Command) (interface{}, error) {
+	c.Lock()
+	c.commands = append(c.commands, &ReceivedCommand{Cmd: cmd, Time: time.Now()})
+	echo := c.behavior.EchoCommands
+	c.Unlock()
+	if !echo {
+		return nil, nil
+	}
+	return map[string]interface{}{
+		"Cmd":   cmd.Cmd,
+		"Param": cmd.Param,
+	}, nil
+}
+
+//当前任务配置，未init返回nil
+func (c *Component) Task() *model.Task {
+	c.Lock()
+	defer c.Unlock()
+	return c.task
+}
+
+//当前持有的资源
+func (c *Component) Resources() map[string]*model.Resource {
+	c.Lock()
+	defer c.Unlock()
+	res := make(map[string]*model.Resource, len(c.resources))
+	for k, v := range c.resources {
+		res[k] = v
+	}
+	return res
+}
+
+//init次数
+func (c *Component) InitCount() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.initCount
+}
+
+//心跳次数
+func (c *Component) HeartCount() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.heartCount
+}
+
+//收到的指令
+func (c *Component) Commands() []*ReceivedCommand {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*ReceivedCommand(nil), c.commands...)
+}
@@ -0,0 +1,120 @@
+package component
+
+import (
+	"dyzs/galaxy/dispatcher"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/sdk"
+	"net"
+	"sync"
+)
+
+//模拟运行时，实现dispatcher.Runtime，每个任务在本机随机端口启动一个模拟组件
+type Runtime struct {
+	sync.Mutex
+	behaviors  map[string]Behavior
+	defaults   Behavior
+	components map[string]*Component
+	servers    map[string]*sdk.Server
+	addresses  map[string]string
+	starts     map[string]int
+}
+
+func NewRuntime(defaults Behavior) *Runtime {
+	return &Runtime{
+		behaviors:  make(map[string]Behavior),
+		defaults:   defaults,
+		components: make(map[string]*Component),
+		servers:    make(map[string]*sdk.Server),
+		addresses:  make(map[string]string),
+		starts:     make(map[string]int),
+	}
+}
+
+//设置任务的行为，已启动的组件立即生效
+func (r *Runtime) SetBehavior(taskId string, behavior Behavior) {
+	r.Lock()
+	r.behaviors[taskId] = behavior
+	c := r.components[taskId]
+	r.Unlock()
+	if c != nil {
+		c.SetBehavior(behavior)
+	}
+}
+
+func (r *Runtime) Start(spec *dispatcher.ContainerSpec) error {
+	_ = r.Stop(spec.TaskID)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	behavior, ok := r.behaviors[spec.TaskID]
+	if !ok {
+		behavior = r.defaults
+	}
+	c := New(behavior)
+	s := sdk.NewServer(c)
+	r.components[spec.TaskID] = c
+	r.servers[spec.TaskID] = s
+	r.addresses[spec.TaskID] = l.Addr().String()
+	r.starts[spec.TaskID]++
+	r.Unlock()
+	go func() {
+		err := s.Serve(l)
+		if err != nil {
+			logger.LOG_INFO("模拟组件退出：", spec.TaskID, "，", err)
+		}
+	}()
+	logger.LOG_INFO("启动模拟组件：", spec.TaskID, "，", l.Addr().String())
+	return nil
+}
+
+func (r *Runtime) Stop(taskId string) error {
+	r.Lock()
+	s := r.servers[taskId]
+	delete(r.servers, taskId)
+	delete(r.components, taskId)
+	delete(r.addresses, taskId)
+	r.Unlock()
+	if s != nil {
+		return s.Close()
+	}
+	return nil
+}
+
+//模拟组件监听的地址，忽略管理端口
+func (r *Runtime) Address(taskId string, managePort int) string {
+	r.Lock()
+	defer r.Unlock()
+	return r.addresses[taskId]
+}
+
+//已启动的模拟组件均为running
+func (r *Runtime) Status() *model.RuntimeStatus {
+	r.Lock()
+	defer r.Unlock()
+	rs := &model.RuntimeStatus{
+		Name:       "mock",
+		Available:  true,
+		Containers: make(map[string]string, len(r.servers)),
+	}
+	for taskId := range r.servers {
+		rs.Containers[taskId] = "running"
+	}
+	return rs
+}
+
+//任务当前的模拟组件，未启动返回nil
+func (r *Runtime) Component(taskId string) *Component {
+	r.Lock()
+	defer r.Unlock()
+	return r.components[taskId]
+}
+
+//任务启动次数
+func (r *Runtime) Starts(taskId string) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.starts[taskId]
+}
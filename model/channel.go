@@ -0,0 +1,28 @@
+package model
+
+//国标设备通道，来自下级的目录上报
+type Channel struct {
+	FromID       string `json:"FromId"`   // 该字段标识来源设备ID，即下级国标编号
+	DeviceID     string `json:"DeviceId"` // 该字段为通道ID
+	Name         string `json:"Name"`
+	Manufacturer string `json:"Manufacturer"`
+	Model        string `json:"Model"`
+	Owner        string `json:"Owner"`
+	CivilCode    string `json:"CivilCode"`
+	Address      string `json:"Address"`
+	Parental     int    `json:"Parental"`
+	ParentID     string `json:"ParentId"`
+	SafetyWay    int    `json:"SafetyWay"`
+	RegisterWay  int    `json:"RegisterWay"`
+	Secrecy      int    `json:"Secrecy"`
+
+	Status    string  `json:"Status"`
+	Longitude float64 `json:"Longitude"`
+	Latitude  float64 `json:"Latitude"`
+	PTZType   int     `json:"PTZType"`
+
+	TypeCode int    `json:"TypeCode"` // 国标编码解析出的类型编码，非国标编码为0
+	Category string `json:"Category"` // 类型分类，见gbid.CATEGORY_*
+
+	LastSeen int64 `json:"LastSeen"` // 最近一次出现在目录上报中的时间（毫秒）
+}
@@ -1,8 +1,10 @@
 package model
 
 import (
+	"encoding/json"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 type Resource struct {
@@ -56,3 +58,24 @@ func CsvToResource(values []string) *Resource {
 	}
 	return r
 }
+
+//资源下的通道编号，MvcChannels支持json数组或逗号分隔
+func (r *Resource) ChannelIds() []string {
+	channels := strings.TrimSpace(r.MvcChannels)
+	if channels == "" {
+		return nil
+	}
+	var ids []string
+	if strings.HasPrefix(channels, "[") {
+		if err := json.Unmarshal([]byte(channels), &ids); err == nil {
+			return ids
+		}
+	}
+	for _, id := range strings.Split(channels, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -27,31 +27,79 @@ type Task struct {
 	CreateTime  int64  `json:"createTime"`
 	UpdateTime  int64  `json:"updateTime"`
 
-	NodeID        string `json:"nodeId"`
-	ResourceBytes string `json:"resourceBytes"`
-	resourceCache []*Resource
+	NodeID         string `json:"nodeId"`
+	ResourceBytes  string `json:"resourceBytes"`
+	resourceCache  []*Resource
+	resourceParsed bool
 }
 
+//解析资源csv并缓存，需在任务被多个协程共享前调用，之后只读
+func (task *Task) ParseResources() {
+	task.resourceCache = parseResources(task.ResourceBytes)
+	task.resourceParsed = true
+}
+
+//任务资源，未调用ParseResources时每次重新解析，不修改任务
 func (task *Task) GetResources() []*Resource {
-	if len(task.resourceCache) > 0 {
+	if task.resourceParsed {
 		return task.resourceCache
 	}
-	if len(task.ResourceBytes) > 0 {
-		csvReader := csv.NewReader(bytes.NewReader([]byte(task.ResourceBytes)))
-		for {
-			row, err := csvReader.Read()
-			if err != nil && err != io.EOF {
-				logger.LOG_WARN("资源csv解析异常，", err)
-				break
-			}
-			if err == io.EOF {
-				break
-			}
-			if len(row) == 0 {
-				continue
-			}
-			task.resourceCache = append(task.resourceCache, CsvToResource(row))
+	return parseResources(task.ResourceBytes)
+}
+
+func parseResources(resourceBytes string) []*Resource {
+	resources := make([]*Resource, 0)
+	if len(resourceBytes) == 0 {
+		return resources
+	}
+	csvReader := csv.NewReader(bytes.NewReader([]byte(resourceBytes)))
+	for {
+		row, err := csvReader.Read()
+		if err != nil && err != io.EOF {
+			logger.LOG_WARN("资源csv解析异常，", err)
+			break
+		}
+		if err == io.EOF {
+			break
 		}
+		if len(row) == 0 {
+			continue
+		}
+		resources = append(resources, CsvToResource(row))
 	}
-	return task.resourceCache
+	return resources
+}
+
+const TASK_STATE_PENDING = "pending"
+const TASK_STATE_RUNNING = "running"
+const TASK_STATE_INVALID_PARAM = "invalidParam"
+const TASK_STATE_INIT_FAILED = "initFailed"
+
+//任务运行状态，随心跳上报中心
+type TaskState struct {
+	TaskID     string `json:"taskId"`
+	State      string `json:"state"`
+	Message    string `json:"message"`
+	UpdateTime int64  `json:"updateTime"`
+
+	ApiVersion int         `json:"apiVersion,omitempty"` //协商的管理接口版本
+	Health     interface{} `json:"health,omitempty"`     //容器心跳返回的健康详情
+	Container  string      `json:"container,omitempty"`  //容器状态，由运行时提供
+	Resources  int         `json:"resources"`            //已下发的资源数
+}
+
+//任务容器运行时状态，随心跳上报中心
+type RuntimeStatus struct {
+	Name       string            `json:"name"`
+	Available  bool              `json:"available"`
+	Version    string            `json:"version,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Containers map[string]string `json:"containers,omitempty"` //任务ID -> 容器状态
+}
+
+//同一资源编号分配到多个任务的冲突，随心跳上报中心
+type RouteConflict struct {
+	Key     string   `json:"key"`
+	TaskIds []string `json:"taskIds"`
+	Policy  string   `json:"policy"`
 }
@@ -0,0 +1,270 @@
+//盒子运行状况采集，数据来自/proc和statfs，随心跳上报中心
+package monitor
+
+import (
+	"bufio"
+	"dyzs/galaxy/constants"
+	"dyzs/galaxy/logger"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var startTime = time.Now()
+
+type CPUStats struct {
+	Cores  int     `json:"cores"`
+	Usage  float64 `json:"usage"` //使用率（%），两次采集之间的平均值
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+type MemoryStats struct {
+	Total     uint64  `json:"total"` //字节
+	Available uint64  `json:"available"`
+	Usage     float64 `json:"usage"` //使用率（%）
+}
+
+type DiskStats struct {
+	Path  string  `json:"path"`
+	Total uint64  `json:"total"` //字节
+	Free  uint64  `json:"free"`
+	Usage float64 `json:"usage"` //使用率（%）
+}
+
+type NetworkStats struct {
+	RxBytes uint64  `json:"rxBytes"` //累计接收字节，不含lo
+	TxBytes uint64  `json:"txBytes"`
+	RxRate  float64 `json:"rxRate"` //字节/秒，两次采集之间的平均值
+	TxRate  float64 `json:"txRate"`
+}
+
+//盒子运行状况
+type Stats struct {
+	Version      string       `json:"version"`
+	StartTime    int64        `json:"startTime"`    //galaxy启动时间（毫秒）
+	Uptime       int64        `json:"uptime"`       //galaxy运行时长（秒）
+	SystemUptime int64        `json:"systemUptime"` //系统运行时长（秒）
+	CPU          CPUStats     `json:"cpu"`
+	Memory       MemoryStats  `json:"memory"`
+	Disks        []*DiskStats `json:"disks"`
+	Network      NetworkStats `json:"network"`
+	IPs          []string     `json:"ips"`
+}
+
+//采集器，保存上次采样用于计算CPU使用率和网络速率
+type Collector struct {
+	sync.Mutex
+	lastTime  time.Time
+	lastBusy  uint64
+	lastTotal uint64
+	lastRx    uint64
+	lastTx    uint64
+}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+//采集当前状况，读取失败的项保持零值
+func (c *Collector) Collect() *Stats {
+	now := time.Now()
+	s := &Stats{
+		Version:   constants.VERSION,
+		StartTime: startTime.UnixNano() / 1e6,
+		Uptime:    int64(now.Sub(startTime).Seconds()),
+		CPU:       CPUStats{Cores: runtime.NumCPU()},
+		IPs:       LocalIPs(),
+	}
+	s.SystemUptime = systemUptime()
+	s.CPU.Load1, s.CPU.Load5, s.CPU.Load15 = loadAvg()
+	s.Memory = memory()
+	s.Disks = disks()
+	busy, total := cpuTimes()
+	rx, tx := netBytes()
+	s.Network.RxBytes, s.Network.TxBytes = rx, tx
+
+	c.Lock()
+	defer c.Unlock()
+	if total > c.lastTotal && busy >= c.lastBusy {
+		s.CPU.Usage = round(float64(busy-c.lastBusy) / float64(total-c.lastTotal) * 100)
+	}
+	if !c.lastTime.IsZero() {
+		seconds := now.Sub(c.lastTime).Seconds()
+		if seconds > 0 && rx >= c.lastRx && tx >= c.lastTx {
+			s.Network.RxRate = round(float64(rx-c.lastRx) / seconds)
+			s.Network.TxRate = round(float64(tx-c.lastTx) / seconds)
+		}
+	}
+	c.lastTime, c.lastBusy, c.lastTotal, c.lastRx, c.lastTx = now, busy, total, rx, tx
+	return s
+}
+
+//本机非回环IPv4地址
+func LocalIPs() []string {
+	ips := make([]string, 0)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logger.LOG_WARN("获取本机ip异常，", err)
+		return ips
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	return ips
+}
+
+//CPU累计时间，busy为非空闲时间
+func cpuTimes() (busy, total uint64) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, 0
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0
+	}
+	var idle uint64
+	for i, v := range fields[1:] {
+		n, _ := strconv.ParseUint(v, 10, 64)
+		total += n
+		//idle、iowait
+		if i == 3 || i == 4 {
+			idle += n
+		}
+	}
+	return total - idle, total
+}
+
+func loadAvg() (load1, load5, load15 float64) {
+	b, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 3 {
+		return
+	}
+	load1, _ = strconv.ParseFloat(fields[0], 64)
+	load5, _ = strconv.ParseFloat(fields[1], 64)
+	load15, _ = strconv.ParseFloat(fields[2], 64)
+	return
+}
+
+func systemUptime() int64 {
+	b, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0
+	}
+	uptime, _ := strconv.ParseFloat(fields[0], 64)
+	return int64(uptime)
+}
+
+func memory() MemoryStats {
+	ms := MemoryStats{}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return ms
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseUint(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			ms.Total = kb * 1024
+		case "MemAvailable:":
+			ms.Available = kb * 1024
+		}
+	}
+	if ms.Total > 0 && ms.Available <= ms.Total {
+		ms.Usage = round(float64(ms.Total-ms.Available) / float64(ms.Total) * 100)
+	}
+	return ms
+}
+
+//磁盘，monitor.disks配置挂载点，默认根目录
+func disks() []*DiskStats {
+	paths := viper.GetStringSlice("monitor.disks")
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	res := make([]*DiskStats, 0, len(paths))
+	for _, p := range paths {
+		fs := syscall.Statfs_t{}
+		err := syscall.Statfs(p, &fs)
+		if err != nil {
+			logger.LOG_WARN("获取磁盘信息异常，", p, "，", err)
+			continue
+		}
+		ds := &DiskStats{
+			Path:  p,
+			Total: fs.Blocks * uint64(fs.Bsize),
+			Free:  fs.Bavail * uint64(fs.Bsize),
+		}
+		if ds.Total > 0 {
+			used := fs.Blocks - fs.Bfree
+			ds.Usage = round(float64(used) / float64(used+fs.Bavail) * 100)
+		}
+		res = append(res, ds)
+	}
+	return res
+}
+
+//网卡累计收发字节，不含lo
+func netBytes() (rx, tx uint64) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		if strings.TrimSpace(line[:idx]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(line[idx+1:])
+		if len(fields) < 9 {
+			continue
+		}
+		r, _ := strconv.ParseUint(fields[0], 10, 64)
+		t, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += r
+		tx += t
+	}
+	return rx, tx
+}
+
+func round(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
@@ -0,0 +1,300 @@
+//上报中心的可靠投递队列：待上报数据持久化在redis hash，按key去重，失败后按退避重试直到中心确认
+package outbox
+
+import (
+	"dyzs/galaxy/constants"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/redis"
+	"dyzs/galaxy/resilience"
+	"encoding/json"
+	"github.com/spf13/viper"
+	"sort"
+	"sync"
+	"time"
+)
+
+//发送器，返回nil表示中心已确认
+type Sender func(payload []byte) error
+
+//合并同一key未投递的旧数据和新数据，未注册时新数据直接替换旧数据
+type Merger func(old, payload []byte) ([]byte, error)
+
+//待投递数据
+type Entry struct {
+	Key         string          `json:"key"`  //去重键，同一键只保留最新数据
+	Kind        string          `json:"kind"` //数据类型，对应发送器
+	Payload     json.RawMessage `json:"payload"`
+	CreateTime  int64           `json:"createTime"` //入队时间（毫秒）
+	Attempts    int             `json:"attempts"`
+	NextAttempt int64           `json:"nextAttempt"` //下次投递时间（毫秒）
+	LastError   string          `json:"lastError"`
+}
+
+//队列状态
+type Stats struct {
+	Depth   int            `json:"depth"`
+	Kinds   map[string]int `json:"kinds"`
+	Oldest  int64          `json:"oldest"` //最早入队时间（毫秒）
+	Entries []*Entry       `json:"entries"`
+}
+
+type Outbox struct {
+	sync.Mutex
+	entries  map[string]*Entry
+	senders  map[string]Sender
+	mergers  map[string]Merger
+	sending  map[string]bool
+	backoff  *resilience.Backoff
+	interval time.Duration
+	wake     chan struct{}
+	once     sync.Once
+	store    sync.Mutex //串行化redis写入
+	redis    *redis.Cache
+}
+
+var box *Outbox
+var boxOnce sync.Once
+
+//全局队列，首次使用时创建
+func Default() *Outbox {
+	boxOnce.Do(func() {
+		box = New()
+	})
+	return box
+}
+
+//创建队列，待上报数据持久化在redis.addr
+func New() *Outbox {
+	return &Outbox{
+		entries: make(map[string]*Entry),
+		senders: make(map[string]Sender),
+		mergers: make(map[string]Merger),
+		sending: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+		redis:   redis.NewRedisCache(0, viper.GetString("redis.addr"), redis.FOREVER),
+	}
+}
+
+//注册发送器
+func (o *Outbox) RegisterSender(kind string, sender Sender) {
+	o.Lock()
+	o.senders[kind] = sender
+	o.Unlock()
+	o.notify()
+}
+
+//注册合并函数
+func (o *Outbox) RegisterMerger(kind string, merger Merger) {
+	o.Lock()
+	defer o.Unlock()
+	o.mergers[kind] = merger
+}
+
+//启动投递，加载redis中未投递的数据；重复调用无效
+func (o *Outbox) Start() {
+	o.once.Do(func() {
+		o.backoff = resilience.NewBackoff(5 * time.Second)
+		o.interval = viper.GetDuration("outbox.interval")
+		if o.interval <= 0 {
+			o.interval = time.Second
+		}
+		o.load()
+		go o.loop()
+	})
+}
+
+//入队，同一key的旧数据被合并或替换，沿用旧数据的重试次数与下次投递时间
+func (o *Outbox) Enqueue(key, kind string, payload []byte) {
+	now := time.Now().UnixNano() / 1e6
+	entry := &Entry{
+		Key:         key,
+		Kind:        kind,
+		Payload:     payload,
+		CreateTime:  now,
+		NextAttempt: now,
+	}
+	o.Lock()
+	if old, ok := o.entries[key]; ok {
+		entry.CreateTime = old.CreateTime
+		entry.Attempts = old.Attempts
+		entry.NextAttempt = old.NextAttempt
+		entry.LastError = old.LastError
+		if merger := o.mergers[kind]; merger != nil && old.Kind == kind {
+			merged, err := merger(old.Payload, payload)
+			if err != nil {
+				logger.LOG_WARN("待上报数据合并异常：", key, "，", err)
+			} else {
+				entry.Payload = merged
+			}
+		}
+	}
+	o.entries[key] = entry
+	o.Unlock()
+	o.sync(key)
+	o.notify()
+}
+
+//待投递数量
+func (o *Outbox) Depth() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.entries)
+}
+
+func (o *Outbox) Stats() *Stats {
+	o.Lock()
+	defer o.Unlock()
+	s := &Stats{
+		Depth:   len(o.entries),
+		Kinds:   make(map[string]int),
+		Entries: make([]*Entry, 0, len(o.entries)),
+	}
+	for _, e := range o.entries {
+		s.Kinds[e.Kind]++
+		if s.Oldest == 0 || e.CreateTime < s.Oldest {
+			s.Oldest = e.CreateTime
+		}
+		copied := *e
+		copied.Payload = nil
+		s.Entries = append(s.Entries, &copied)
+	}
+	sort.Slice(s.Entries, func(i, j int) bool {
+		return s.Entries[i].CreateTime < s.Entries[j].CreateTime
+	})
+	return s
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) loop() {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		o.flush()
+		select {
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+//投递到期的数据，按入队顺序逐条发送
+func (o *Outbox) flush() {
+	now := time.Now().UnixNano() / 1e6
+	o.Lock()
+	due := make([]*Entry, 0)
+	for _, e := range o.entries {
+		if e.NextAttempt <= now && o.senders[e.Kind] != nil && !o.sending[e.Key] {
+			due = append(due, e)
+		}
+	}
+	o.Unlock()
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreateTime < due[j].CreateTime
+	})
+	for _, e := range due {
+		o.send(e)
+	}
+}
+
+func (o *Outbox) send(e *Entry) {
+	o.Lock()
+	sender := o.senders[e.Kind]
+	o.sending[e.Key] = true
+	o.Unlock()
+
+	err := sender(e.Payload)
+
+	o.Lock()
+	delete(o.sending, e.Key)
+	//发送期间同一key已有新数据入队，保留新数据，重试状态按本次发送结果更新
+	if cur := o.entries[e.Key]; cur != e {
+		if cur == nil {
+			o.Unlock()
+			return
+		}
+		if err == nil {
+			cur.Attempts = 0
+			cur.NextAttempt = 0
+			cur.LastError = ""
+			o.Unlock()
+			o.sync(cur.Key)
+			return
+		}
+		e = cur
+	}
+	if err == nil {
+		delete(o.entries, e.Key)
+		o.Unlock()
+		if e.Attempts > 0 {
+			logger.LOG_WARN("上报中心恢复：", e.Key, "，重试次数：", e.Attempts)
+		}
+		o.sync(e.Key)
+		return
+	}
+	e.Attempts++
+	e.LastError = err.Error()
+	e.NextAttempt = time.Now().Add(o.backoff.Next(e.Attempts)).UnixNano() / 1e6
+	o.Unlock()
+	if e.Attempts == 1 || e.Attempts%10 == 0 {
+		logger.LOG_WARN("上报中心失败，稍后重试：", e.Key, "，第", e.Attempts, "次，", err)
+	}
+	o.sync(e.Key)
+}
+
+func (o *Outbox) load() {
+	values, err := o.redis.HGetAll(constants.REDIS_KEY_OUTBOX)
+	if err != nil {
+		logger.LOG_WARN("redis 加载待上报数据异常，", err)
+		return
+	}
+	o.Lock()
+	defer o.Unlock()
+	for key, value := range values {
+		e := &Entry{}
+		err := json.Unmarshal(value, e)
+		if err != nil {
+			logger.LOG_WARN("待上报数据解析异常：", key, "，", err)
+			continue
+		}
+		//重启后立即投递
+		e.NextAttempt = 0
+		if _, ok := o.entries[key]; !ok {
+			o.entries[key] = e
+		}
+	}
+	if len(o.entries) > 0 {
+		logger.LOG_INFO("加载待上报数据：", len(o.entries))
+	}
+}
+
+//将key的当前状态同步到redis：在队列中则写入，否则删除；持久化失败时仅保留在内存
+func (o *Outbox) sync(key string) {
+	o.store.Lock()
+	defer o.store.Unlock()
+	o.Lock()
+	e, ok := o.entries[key]
+	var value []byte
+	var err error
+	if ok {
+		value, err = json.Marshal(e)
+	}
+	o.Unlock()
+	if err != nil {
+		logger.LOG_WARN("待上报数据序列化异常：", key, "，", err)
+		return
+	}
+	if ok {
+		err = o.redis.HSet(constants.REDIS_KEY_OUTBOX, key, value)
+	} else {
+		_, err = o.redis.Hdel(constants.REDIS_KEY_OUTBOX, key)
+	}
+	if err != nil {
+		logger.LOG_WARN("redis 同步待上报数据异常，", err)
+	}
+}
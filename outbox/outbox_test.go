@@ -0,0 +1,86 @@
+package outbox
+
+import (
+	"dyzs/galaxy/resilience"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestOutbox() *Outbox {
+	o := New()
+	o.backoff = resilience.NewBackoff(time.Minute)
+	return o
+}
+
+func (o *Outbox) entry(key string) Entry {
+	o.Lock()
+	defer o.Unlock()
+	return *o.entries[key]
+}
+
+//同一key重新入队时沿用重试状态，不会绕过退避立即重发
+func TestEnqueueKeepsRetryState(t *testing.T) {
+	o := newTestOutbox()
+	sent := 0
+	o.RegisterSender("test", func(payload []byte) error {
+		sent++
+		return errors.New("中心不可用")
+	})
+	o.Enqueue("k", "test", []byte(`1`))
+	o.flush()
+	if sent != 1 {
+		t.Fatalf("应投递1次，实际%d次", sent)
+	}
+	failed := o.entry("k")
+	if failed.Attempts != 1 || failed.NextAttempt <= time.Now().UnixNano()/1e6 {
+		t.Fatalf("失败后应退避：%+v", failed)
+	}
+
+	o.Enqueue("k", "test", []byte(`2`))
+	replaced := o.entry("k")
+	if replaced.Attempts != failed.Attempts || replaced.NextAttempt != failed.NextAttempt || replaced.LastError != failed.LastError {
+		t.Fatalf("重新入队重置了重试状态：%+v", replaced)
+	}
+	if string(replaced.Payload) != `2` || replaced.CreateTime != failed.CreateTime {
+		t.Fatalf("重新入队数据错误：%+v", replaced)
+	}
+	o.flush()
+	if sent != 1 {
+		t.Fatalf("退避期间不应投递，实际%d次", sent)
+	}
+}
+
+//发送期间入队的新数据保留，并按本次发送结果更新重试状态
+func TestEnqueueWhileSending(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"发送成功", nil, 0},
+		{"发送失败", errors.New("中心不可用"), 1},
+	}
+	for _, c := range cases {
+		o := newTestOutbox()
+		o.RegisterSender("test", func(payload []byte) error {
+			if string(payload) == `1` {
+				o.Enqueue("k", "test", []byte(`2`))
+			}
+			return c.err
+		})
+		o.Enqueue("k", "test", []byte(`1`))
+		o.send(o.entries["k"])
+		e := o.entry("k")
+		if string(e.Payload) != `2` {
+			t.Fatalf("%s：新数据丢失：%+v", c.name, e)
+		}
+		if e.Attempts != c.attempts {
+			t.Errorf("%s：重试次数应为%d：%+v", c.name, c.attempts, e)
+		}
+		due := e.NextAttempt <= time.Now().UnixNano()/1e6
+		if due != (c.err == nil) {
+			t.Errorf("%s：下次投递时间错误：%+v", c.name, e)
+		}
+	}
+}
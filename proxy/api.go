@@ -1,10 +1,24 @@
 package proxy
 
-import "dyzs/galaxy/model"
+import (
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/monitor"
+	"github.com/spf13/viper"
+)
 
 type CenterProxy interface {
 	//心跳、注册、获取更新任务
-	Heart(localTasks []*model.Task) (*HeartResonse, error)
+	Heart(localTasks []*model.Task, report *HeartReport) (*HeartResonse, error)
+	//补全中心推送的任务（盒子ID、资源）
+	PrepareTasks(localTasks []*model.Task, tasks []*model.Task, nodeId string)
+}
+
+//心跳附带上报的盒子运行信息
+type HeartReport struct {
+	TaskStates []*model.TaskState     `json:"taskStates"`
+	Conflicts  []*model.RouteConflict `json:"conflicts"`
+	Stats      *monitor.Stats         `json:"stats"`
+	Runtime    *model.RuntimeStatus   `json:"runtime"`
 }
 
 type HeartResonse struct {
@@ -12,8 +26,61 @@ type HeartResonse struct {
 	Tasks []*model.Task `json:"tasks"`
 }
 
+//可选：中心主动通知任务变更，调度器收到后立即心跳
+type Notifier interface {
+	Changes() <-chan struct{}
+}
+
+//按center.protocol创建中心代理，默认http；local为仅使用本地清单，
+//其他协议配置了center.local.dir时与本地清单组合（center.local.mode）
 func NewCenterProxy() CenterProxy {
-	hcp := &HttpCenterProxy{}
-	hcp.Init()
-	return hcp
+	var remote CenterProxy
+	switch viper.GetString("center.protocol") {
+	case "local":
+		lcp := &LocalCenterProxy{}
+		lcp.Init()
+		return lcp
+	case "mqtt":
+		mcp := &MqttCenterProxy{}
+		mcp.Init()
+		remote = mcp
+	default:
+		hcp := &HttpCenterProxy{}
+		hcp.Init()
+		remote = hcp
+	}
+	if viper.GetString("center.local.dir") == "" {
+		return remote
+	}
+	lcp := &LocalCenterProxy{}
+	lcp.Init()
+	ccp := &CombinedCenterProxy{
+		Remote: remote,
+		Local:  lcp,
+		Mode:   viper.GetString("center.local.mode"),
+	}
+	ccp.Init()
+	return ccp
+}
+
+//心跳内容
+func generateHeartBody(report *HeartReport) map[string]interface{} {
+	m := make(map[string]interface{})
+	m["serialNumber"] = viper.GetString("sn")
+	m["model"] = viper.GetString("model")
+	m["name"] = viper.GetString("name")
+	if report != nil {
+		m["taskStates"] = report.TaskStates
+		m["conflicts"] = report.Conflicts
+		if report.Stats != nil {
+			m["version"] = report.Stats.Version
+			m["uptime"] = report.Stats.Uptime
+			m["ips"] = report.Stats.IPs
+			m["stats"] = report.Stats
+		}
+		if report.Runtime != nil {
+			m["runtime"] = report.Runtime
+		}
+	}
+	return m
 }
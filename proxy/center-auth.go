@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dyzs/galaxy/constants"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/resilience"
+	"dyzs/galaxy/util/uuid"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//签名请求头
+const (
+	HEADER_BOX_SN    = "X-Box-SN"
+	HEADER_TIMESTAMP = "X-Timestamp"
+	HEADER_NONCE     = "X-Nonce"
+	HEADER_SIGNATURE = "X-Signature"
+)
+
+const _DEFAULT_URL_PROVISION = "/management/box/provision"
+
+//中心注册下发的凭证
+type BoxCredential struct {
+	SerialNumber string `json:"serialNumber"`
+	Secret       string `json:"secret"`
+}
+
+//盒子认证：开启center.auth.enable后，首次使用注册码（center.auth.provisionCode）向中心注册获取与SN绑定的密钥，
+//密钥保存在redis，之后访问中心的请求均带HMAC-SHA256签名
+type centerAuth struct {
+	sync.Mutex
+	credential *BoxCredential
+	//注册串行进行，注册期间不持有凭证锁
+	provisionLock sync.Mutex
+}
+
+//中心返回401，中心可达，不计入中心故障
+var ErrUnauthorized = errors.New("中心认证失败")
+
+var cauth = &centerAuth{}
+
+var provisionClient *http.Client
+var provisionClientOnce sync.Once
+
+//是否开启认证
+func AuthEnabled() bool {
+	return viper.GetBool("center.auth.enable")
+}
+
+//签名内容：METHOD\nURI\nTIMESTAMP\nNONCE\nSHA256(BODY)
+func SignPayload(method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{strings.ToUpper(method), uri, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")
+}
+
+//计算签名
+func Sign(secret, method, uri, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(SignPayload(method, uri, timestamp, nonce, body)))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//生成签名请求头，同时返回签名所用凭证；未开启认证时返回空请求头和nil凭证
+func SignHeader(method, uri string, body []byte) (http.Header, *BoxCredential, error) {
+	header := http.Header{}
+	if !AuthEnabled() {
+		return header, nil, nil
+	}
+	credential, err := cauth.get()
+	if err != nil {
+		return nil, nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().UnixNano()/1e6, 10)
+	nonce := uuid.UUIDShort()
+	header.Set(HEADER_BOX_SN, credential.SerialNumber)
+	header.Set(HEADER_TIMESTAMP, timestamp)
+	header.Set(HEADER_NONCE, nonce)
+	header.Set(HEADER_SIGNATURE, Sign(credential.Secret, method, uri, timestamp, nonce, body))
+	return header, credential, nil
+}
+
+//请求签名，返回签名所用凭证，供CheckAuth判断
+func SignRequest(req *http.Request, body []byte) (*BoxCredential, error) {
+	header, credential, err := SignHeader(req.Method, req.URL.RequestURI(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return credential, nil
+}
+
+//中心返回401时返回ErrUnauthorized；signedWith仍是当前凭证时清除，下次请求重新注册，
+//已被其他请求重新注册替换的凭证不再清除
+func CheckAuth(statusCode int, signedWith *BoxCredential) error {
+	if statusCode != http.StatusUnauthorized {
+		return nil
+	}
+	if AuthEnabled() && signedWith != nil && cauth.reset(signedWith) {
+		logger.LOG_WARN("中心认证失败，重新注册")
+	}
+	return ErrUnauthorized
+}
+
+func (ca *centerAuth) current(sn string) *BoxCredential {
+	ca.Lock()
+	defer ca.Unlock()
+	if ca.credential != nil && ca.credential.SerialNumber == sn {
+		return ca.credential
+	}
+	return nil
+}
+
+func (ca *centerAuth) get() (*BoxCredential, error) {
+	sn := viper.GetString("sn")
+	if credential := ca.current(sn); credential != nil {
+		return credential, nil
+	}
+	ca.provisionLock.Lock()
+	defer ca.provisionLock.Unlock()
+	//等待期间其他请求可能已注册完成
+	if credential := ca.current(sn); credential != nil {
+		return credential, nil
+	}
+	credential := &BoxCredential{}
+	err := redisCache().StringGet(constants.REDIS_KEY_BOX_CREDENTIAL, credential)
+	if err != nil {
+		logger.LOG_WARN("redis 获取盒子凭证异常，", err)
+	}
+	if credential.Secret == "" || credential.SerialNumber != sn {
+		credential, err = provision()
+		if err != nil {
+			return nil, err
+		}
+		err = redisCache().StringSet(constants.REDIS_KEY_BOX_CREDENTIAL, credential)
+		if err != nil {
+			logger.LOG_ERROR("盒子凭证缓存入redis异常，", err)
+		}
+	}
+	ca.Lock()
+	ca.credential = credential
+	ca.Unlock()
+	return credential, nil
+}
+
+//credential仍是当前凭证时清除，返回是否清除
+func (ca *centerAuth) reset(credential *BoxCredential) bool {
+	//与注册串行，避免删除刚注册写入redis的新凭证
+	ca.provisionLock.Lock()
+	defer ca.provisionLock.Unlock()
+	ca.Lock()
+	if ca.credential != credential {
+		ca.Unlock()
+		return false
+	}
+	ca.credential = nil
+	ca.Unlock()
+	_, err := redisCache().Delete(constants.REDIS_KEY_BOX_CREDENTIAL)
+	if err != nil {
+		logger.LOG_WARN("redis 删除盒子凭证异常，", err)
+	}
+	return true
+}
+
+//向中心注册
+func provision() (*BoxCredential, error) {
+	urlProvision := viper.GetString("center.auth.url-provision")
+	if urlProvision == "" {
+		urlProvision = _DEFAULT_URL_PROVISION
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"serialNumber":  viper.GetString("sn"),
+		"model":         viper.GetString("model"),
+		"name":          viper.GetString("name"),
+		"provisionCode": viper.GetString("center.auth.provisionCode"),
+	})
+	var resBytes []byte
+	breaker := resilience.GetBreaker(resilience.ENDPOINT_PROVISION)
+	err := breaker.Do(func() error {
+		return provisionRequest(getProvisionClient(), urlProvision, body, &resBytes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	wrap := &HttpResponseWrapper{}
+	err = jsoniter.Unmarshal(resBytes, wrap)
+	if err != nil {
+		return nil, err
+	}
+	credential := &BoxCredential{}
+	err = jsoniter.Unmarshal(wrap.Data, credential)
+	if err != nil {
+		return nil, err
+	}
+	if credential.Secret == "" {
+		return nil, errors.New("盒子注册失败：中心未下发密钥")
+	}
+	credential.SerialNumber = viper.GetString("sn")
+	logger.LOG_WARN("盒子注册成功")
+	return credential, nil
+}
+
+func provisionRequest(client *http.Client, urlProvision string, body []byte, resBytes *[]byte) error {
+	return Centers().Do(func(ep *CenterEndpoint) error {
+		res, err := client.Post(HttpScheme()+"://"+ep.Address()+urlProvision, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err := res.Body.Close()
+			if err != nil {
+				logger.LOG_WARN("关闭res失败", err)
+			}
+		}()
+		*resBytes, err = ioutil.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode == http.StatusUnauthorized {
+			logger.LOG_ERROR("盒子注册被拒绝：", string(*resBytes))
+			return ErrUnauthorized
+		}
+		if res.StatusCode != http.StatusOK {
+			return errors.New("盒子注册失败：" + string(*resBytes))
+		}
+		return nil
+	})
+}
+
+func getProvisionClient() *http.Client {
+	provisionClientOnce.Do(func() {
+		provisionClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: TLSConfig()},
+			Timeout:   resilience.Timeout(resilience.ENDPOINT_PROVISION),
+		}
+	})
+	return provisionClient
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"dyzs/galaxy/constants"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/redis"
+	"errors"
+	"github.com/spf13/viper"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const _DEFAULT_CENTER_FAIL_THRESHOLD = 2
+const _DEFAULT_CENTER_COOLDOWN = 60 * time.Second
+
+//中心节点
+type CenterEndpoint struct {
+	Host      string    `json:"host"`
+	Port      string    `json:"port"`
+	Active    bool      `json:"active"`
+	Healthy   bool      `json:"healthy"`
+	Fails     int       `json:"fails"`
+	LastError string    `json:"lastError"`
+	DownUntil time.Time `json:"downUntil"`
+}
+
+func (ce *CenterEndpoint) Address() string {
+	return net.JoinHostPort(ce.Host, ce.Port)
+}
+
+//多中心选择：粘滞在当前可用中心，连续失败达到阈值后切换到下一个健康中心，
+//心跳、websocket、通道上报共用
+type CenterEndpoints struct {
+	sync.Mutex
+	config    string
+	endpoints []*CenterEndpoint
+	active    int
+	threshold int
+	cooldown  time.Duration
+	recorded  string
+}
+
+var centers = &CenterEndpoints{}
+
+var sharedRedis *redis.Cache
+var sharedRedisOnce sync.Once
+
+//包内共用的redis连接池，首次使用时按redis.addr创建
+func redisCache() *redis.Cache {
+	sharedRedisOnce.Do(func() {
+		sharedRedis = redis.NewRedisCache(0, viper.GetString("redis.addr"), redis.FOREVER)
+	})
+	return sharedRedis
+}
+
+//全局中心列表
+func Centers() *CenterEndpoints {
+	return centers
+}
+
+//读取配置，center.hosts为地址列表（host或host:port，缺省端口为center.managePort），未配置时使用center.host
+func (ces *CenterEndpoints) load() {
+	hosts := viper.GetStringSlice("center.hosts")
+	if len(hosts) == 1 && strings.Contains(hosts[0], ",") {
+		hosts = strings.Split(hosts[0], ",")
+	}
+	if len(hosts) == 0 && viper.GetString("center.host") != "" {
+		hosts = []string{viper.GetString("center.host")}
+	}
+	managePort := viper.GetString("center.managePort")
+	config := strings.Join(hosts, ",") + "|" + managePort
+	ces.threshold = viper.GetInt("center.failThreshold")
+	if ces.threshold <= 0 {
+		ces.threshold = _DEFAULT_CENTER_FAIL_THRESHOLD
+	}
+	ces.cooldown = viper.GetDuration("center.failCooldown")
+	if ces.cooldown <= 0 {
+		ces.cooldown = _DEFAULT_CENTER_COOLDOWN
+	}
+	if config == ces.config {
+		return
+	}
+	var active string
+	if ces.active < len(ces.endpoints) {
+		active = ces.endpoints[ces.active].Address()
+	}
+	ces.config = config
+	ces.endpoints = nil
+	ces.active = 0
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(h)
+		if err != nil {
+			host, port = h, managePort
+		}
+		ep := &CenterEndpoint{Host: host, Port: port, Healthy: true}
+		if ep.Address() == active {
+			ces.active = len(ces.endpoints)
+		}
+		ces.endpoints = append(ces.endpoints, ep)
+	}
+}
+
+//当前中心
+func (ces *CenterEndpoints) Current() (*CenterEndpoint, error) {
+	ces.Lock()
+	defer ces.Unlock()
+	ces.load()
+	if len(ces.endpoints) == 0 {
+		return nil, errors.New("配置中心地址为空")
+	}
+	ep := *ces.endpoints[ces.active]
+	return &ep, nil
+}
+
+//当前中心地址，未配置时为空
+func (ces *CenterEndpoints) Host() (host, port string) {
+	ep, err := ces.Current()
+	if err != nil {
+		return "", ""
+	}
+	return ep.Host, ep.Port
+}
+
+//上报请求结果
+func (ces *CenterEndpoints) Report(address string, err error) {
+	//认证失败说明中心可达，不计入故障
+	if err == ErrUnauthorized {
+		err = nil
+	}
+	ces.Lock()
+	var recordHost string
+	defer func() {
+		ces.Unlock()
+		if recordHost != "" {
+			ces.record(recordHost)
+		}
+	}()
+	for i, ep := range ces.endpoints {
+		if ep.Address() != address {
+			continue
+		}
+		if err == nil {
+			ep.Healthy = true
+			ep.Fails = 0
+			ep.LastError = ""
+			ep.DownUntil = time.Time{}
+			if i == ces.active && ces.recorded != ep.Host {
+				recordHost = ep.Host
+			}
+			return
+		}
+		ep.Fails++
+		ep.LastError = err.Error()
+		if ep.Fails < ces.threshold {
+			return
+		}
+		if ep.Healthy {
+			logger.LOG_WARN("中心不可用：", address, "，", err)
+		}
+		ep.Healthy = false
+		ep.DownUntil = time.Now().Add(ces.cooldown)
+		if i == ces.active {
+			ces.failover()
+		}
+		return
+	}
+}
+
+//切换到下一个健康中心，全部不可用时切到冷却最早结束的
+func (ces *CenterEndpoints) failover() {
+	n := len(ces.endpoints)
+	if n <= 1 {
+		return
+	}
+	now := time.Now()
+	next := -1
+	for i := 1; i < n; i++ {
+		idx := (ces.active + i) % n
+		ep := ces.endpoints[idx]
+		if ep.Healthy || now.After(ep.DownUntil) {
+			next = idx
+			break
+		}
+	}
+	if next < 0 {
+		next = (ces.active + 1) % n
+		for i := 0; i < n; i++ {
+			if i != ces.active && ces.endpoints[i].DownUntil.Before(ces.endpoints[next].DownUntil) {
+				next = i
+			}
+		}
+	}
+	logger.LOG_WARN("切换中心：", ces.endpoints[ces.active].Address(), " -> ", ces.endpoints[next].Address())
+	ces.active = next
+}
+
+//依次尝试中心直到成功，成功的中心成为当前中心
+func (ces *CenterEndpoints) Do(fn func(ep *CenterEndpoint) error) error {
+	ces.Lock()
+	ces.load()
+	n := len(ces.endpoints)
+	ces.Unlock()
+	if n == 0 {
+		return errors.New("配置中心地址为空")
+	}
+	var err error
+	for i := 0; i < n; i++ {
+		var ep *CenterEndpoint
+		ep, err = ces.Current()
+		if err != nil {
+			return err
+		}
+		err = fn(ep)
+		ces.Report(ep.Address(), err)
+		if err == nil {
+			return nil
+		}
+		//未切换说明还没达到失败阈值
+		cur, _ := ces.Current()
+		if cur == nil || cur.Address() == ep.Address() {
+			return err
+		}
+	}
+	return err
+}
+
+//中心列表及状态
+func (ces *CenterEndpoints) Snapshot() []*CenterEndpoint {
+	ces.Lock()
+	defer ces.Unlock()
+	ces.load()
+	res := make([]*CenterEndpoint, 0, len(ces.endpoints))
+	for i, ep := range ces.endpoints {
+		c := *ep
+		c.Active = i == ces.active
+		res = append(res, &c)
+	}
+	return res
+}
+
+//当前中心写入redis，供任务组件获取
+func (ces *CenterEndpoints) record(host string) {
+	err := redisCache().StringSet(constants.REDIS_KEY_CENTERHOST, host)
+	if err != nil {
+		logger.LOG_ERROR("中心地址缓存入redis异常，", err)
+		return
+	}
+	ces.Lock()
+	ces.recorded = host
+	ces.Unlock()
+}
@@ -2,16 +2,15 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"dyzs/galaxy/concurrent"
-	"dyzs/galaxy/constants"
 	"dyzs/galaxy/logger"
 	"dyzs/galaxy/model"
-	"dyzs/galaxy/redis"
+	"dyzs/galaxy/resilience"
 	"encoding/json"
 	"errors"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/spf13/viper"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -19,11 +18,9 @@ import (
 )
 
 type HttpCenterProxy struct {
-	address  string
-	client   *http.Client
-	executor *concurrent.Executor
-
-	localCenterHost string
+	client          *http.Client
+	executor        *concurrent.Executor
+	resourceBackoff *resilience.KeyedBackoff
 }
 
 type HttpResponseWrapper struct {
@@ -39,64 +36,80 @@ func (hcp *HttpCenterProxy) Init() {
 			MaxIdleConnsPerHost: 5,
 			MaxConnsPerHost:     5,
 			IdleConnTimeout:     30 * time.Second,
+			TLSClientConfig:     TLSConfig(),
 		},
-		Timeout: 3 * time.Second,
+		//按接口的超时之外的兜底
+		Timeout: 60 * time.Second,
+	}
+	//资源并发数，默认3
+	concurrency := viper.GetInt("resilience.resourceConcurrency")
+	if concurrency <= 0 {
+		concurrency = 3
 	}
-	hcp.executor = concurrent.NewExecutor(10)
+	hcp.executor = concurrent.NewExecutor(concurrency)
+	hcp.resourceBackoff = resilience.NamedKeyedBackoff(resilience.ENDPOINT_RESOURCE, resilience.NewBackoff(10*time.Second))
 }
 
-func (hcp *HttpCenterProxy) Heart(localTasks []*model.Task) (hr *HeartResonse, err error) {
-	host := viper.GetString("center.host")
-	managePort := viper.GetString("center.managePort")
+//按接口设置请求超时
+func withTimeout(req *http.Request, endpoint string) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(req.Context(), resilience.Timeout(endpoint))
+	return req.WithContext(ctx), cancel
+}
+
+func (hcp *HttpCenterProxy) Heart(localTasks []*model.Task, report *HeartReport) (hr *HeartResonse, err error) {
 	urlHeart := viper.GetString("center.url-heart")
-	if host == "" {
-		return nil, errors.New("配置中心地址为空")
-	}
-	if managePort == "" {
-		return nil, errors.New("配置中心管理端口为空")
-	}
 	if urlHeart == "" {
 		return nil, errors.New("配置中心心跳接口地址为空")
 	}
-	if hcp.localCenterHost != host {
-		redisClient := redis.NewRedisCache(0, viper.GetString("redis.addr"), redis.FOREVER)
-		err = redisClient.StringSet(constants.REDIS_KEY_CENTERHOST, host)
-		if err != nil {
-			logger.LOG_ERROR("BoxId缓存入redis异常，", err)
-		} else {
-			hcp.localCenterHost = host
-		}
-		_ = redisClient.Close()
-	}
-	hcp.address = host + ":" + managePort
 	var lastUpdateTime int64
-	localTaskMap := make(map[string]*model.Task)
 	for _, v := range localTasks {
-		localTaskMap[v.ID] = v
 		if v.UpdateTime > lastUpdateTime {
 			lastUpdateTime = v.UpdateTime
 		}
 	}
-	url := "http://" + hcp.address + urlHeart + "?time=" + strconv.FormatInt(lastUpdateTime, 10)
-	logger.LOG_INFO("heart-request:", url)
-	res, err := hcp.client.Post(url, "application/json", hcp.generateHeartRequest())
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		err := res.Body.Close()
-		if err != nil {
-			logger.LOG_WARN("关闭res失败", err)
-		}
-	}()
-	resBytes, err := ioutil.ReadAll(res.Body)
+	body := hcp.generateHeartRequest(report)
+	var resBytes []byte
+	err = resilience.GetBreaker(resilience.ENDPOINT_HEART).Do(func() error {
+		return Centers().Do(func(ep *CenterEndpoint) error {
+			url := HttpScheme() + "://" + ep.Address() + urlHeart + "?time=" + strconv.FormatInt(lastUpdateTime, 10)
+			logger.LOG_INFO("heart-request:", url)
+			req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req, cancel := withTimeout(req, resilience.ENDPOINT_HEART)
+			defer cancel()
+			req.Header.Set("Content-Type", "application/json")
+			credential, err := SignRequest(req, body)
+			if err != nil {
+				return err
+			}
+			res, err := hcp.client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := res.Body.Close()
+				if err != nil {
+					logger.LOG_WARN("关闭res失败", err)
+				}
+			}()
+			resBytes, err = ioutil.ReadAll(res.Body)
+			if err != nil {
+				return err
+			}
+			if err := CheckAuth(res.StatusCode, credential); err != nil {
+				return err
+			}
+			if res.StatusCode != http.StatusOK {
+				return errors.New(string(resBytes))
+			}
+			return nil
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
-	_ = res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, errors.New(string(resBytes))
-	}
 	wrap := &HttpResponseWrapper{}
 	hr = &HeartResonse{}
 	err = jsoniter.Unmarshal(resBytes, wrap)
@@ -107,10 +120,19 @@ func (hcp *HttpCenterProxy) Heart(localTasks []*model.Task) (hr *HeartResonse, e
 	if err != nil {
 		return nil, err
 	}
+	hcp.PrepareTasks(localTasks, hr.Tasks, hr.Node.Id)
+	return hr, nil
+}
+
+func (hcp *HttpCenterProxy) PrepareTasks(localTasks []*model.Task, tasks []*model.Task, nodeId string) {
+	localTaskMap := make(map[string]*model.Task)
+	for _, v := range localTasks {
+		localTaskMap[v.ID] = v
+	}
 	//请求资源（未获取过得和变更的）
 	var unloadResourceTask []*model.Task
-	for _, t := range hr.Tasks {
-		t.NodeID = hr.Node.Id
+	for _, t := range tasks {
+		t.NodeID = nodeId
 
 		rrr, _ := jsoniter.Marshal(t)
 		logger.LOG_INFO("task:", string(rrr))
@@ -126,50 +148,85 @@ func (hcp *HttpCenterProxy) Heart(localTasks []*model.Task) (hr *HeartResonse, e
 	if len(unloadResourceTask) > 0 {
 		hcp.loadResourcesOfTasks(unloadResourceTask)
 	}
-	return hr, nil
 }
 
-func (hcp *HttpCenterProxy) generateHeartRequest() io.Reader {
-	m := make(map[string]string)
-	m["serialNumber"] = viper.GetString("sn")
-	m["model"] = viper.GetString("model")
-	m["name"] = viper.GetString("name")
-	b, err := json.Marshal(m)
+func (hcp *HttpCenterProxy) generateHeartRequest(report *HeartReport) []byte {
+	b, err := json.Marshal(generateHeartBody(report))
 	if err != nil {
 		logger.LOG_WARN(err)
 	}
-	return bytes.NewReader(b)
+	return b
 }
 
-//获取任务的资源集合
+//获取任务的资源集合，同一资源只请求一次，失败的资源按退避间隔重试，中心熔断时跳过
 func (hcp *HttpCenterProxy) loadResourcesOfTasks(tasks []*model.Task) {
 	urlResource := viper.GetString("center.url-resource")
-	var requests []func()
+	resourceTasks := make(map[string][]*model.Task)
 	for _, t := range tasks {
-		func(task *model.Task) {
+		if !hcp.resourceBackoff.Ready(t.ResourceId) {
+			logger.LOG_INFO("任务资源退避中，task:", t.ID, "，resourceId:", t.ResourceId)
+			continue
+		}
+		resourceTasks[t.ResourceId] = append(resourceTasks[t.ResourceId], t)
+	}
+	breaker := resilience.GetBreaker(resilience.ENDPOINT_RESOURCE)
+	var requests []func()
+	for resourceId, ts := range resourceTasks {
+		func(resourceId string, ts []*model.Task) {
 			requests = append(requests, func() {
-				res, err := hcp.client.Get("http://" + hcp.address + urlResource + "/" + task.ResourceId)
-				if err != nil {
-					logger.LOG_WARN("获取任务资源异常，", err)
-					return
-				}
-				if res.StatusCode != 200 {
-					logger.LOG_WARN("获取任务资源异常，code:", res.StatusCode)
-					return
+				var resBytes []byte
+				var statusErr error
+				err := breaker.Do(func() error {
+					return Centers().Do(func(ep *CenterEndpoint) error {
+						req, err := http.NewRequest(http.MethodGet, HttpScheme()+"://"+ep.Address()+urlResource+"/"+resourceId, nil)
+						if err != nil {
+							return err
+						}
+						req, cancel := withTimeout(req, resilience.ENDPOINT_RESOURCE)
+						defer cancel()
+						credential, err := SignRequest(req, nil)
+						if err != nil {
+							return err
+						}
+						res, err := hcp.client.Do(req)
+						if err != nil {
+							return err
+						}
+						defer func() {
+							err := res.Body.Close()
+							if err != nil {
+								logger.LOG_WARN("关闭res失败", err)
+							}
+						}()
+						if err := CheckAuth(res.StatusCode, credential); err != nil {
+							return err
+						}
+						if res.StatusCode >= 500 {
+							return errors.New("code:" + strconv.Itoa(res.StatusCode))
+						}
+						//资源不存在等业务错误不切换中心、不计入熔断
+						if res.StatusCode != 200 {
+							statusErr = errors.New("code:" + strconv.Itoa(res.StatusCode))
+							return nil
+						}
+						resBytes, err = ioutil.ReadAll(res.Body)
+						return err
+					})
+				})
+				if err == nil {
+					err = statusErr
 				}
-				defer func() {
-					err := res.Body.Close()
-					if err != nil {
-						logger.LOG_WARN("关闭res失败", err)
-					}
-				}()
-				resBytes, err := ioutil.ReadAll(res.Body)
 				if err != nil {
+					next := hcp.resourceBackoff.Failure(resourceId)
+					logger.LOG_INFO("获取任务资源异常，resourceId:", resourceId, "，", err, "，下次重试：", next.Format("15:04:05"))
 					return
 				}
-				task.ResourceBytes = string(resBytes)
+				hcp.resourceBackoff.Success(resourceId)
+				for _, t := range ts {
+					t.ResourceBytes = string(resBytes)
+				}
 			})
-		}(t)
+		}(resourceId, ts)
 	}
 	err := hcp.executor.SubmitSyncBatch(requests)
 	if err != nil {
@@ -0,0 +1,228 @@
+package proxy_test
+
+import (
+	"dyzs/galaxy/mock/center"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/proxy"
+	"encoding/json"
+	"github.com/spf13/viper"
+	"net/http"
+	"testing"
+)
+
+const testResourceCsv = "r1,34020000001320000001\nr2,34020000001320000002\n"
+
+func newTestCenter() *center.Center {
+	c := center.New()
+	c.ApplyConfig()
+	c.SetNodeId("box-test")
+	c.SetTasks(&model.Task{
+		ID:         "t1",
+		Status:     model.TASK_STATUS_RUNNING,
+		ResourceId: "res1",
+		UpdateTime: 1,
+	})
+	c.SetResource("res1", testResourceCsv)
+	return c
+}
+
+func newTestProxy() *proxy.HttpCenterProxy {
+	hcp := &proxy.HttpCenterProxy{}
+	hcp.Init()
+	return hcp
+}
+
+//心跳返回盒子ID和任务，并补全资源csv
+func TestHttpCenterProxyHeart(t *testing.T) {
+	c := newTestCenter()
+	defer c.Close()
+	hr, err := newTestProxy().Heart(nil, &proxy.HeartReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hr.Node.Id != "box-test" {
+		t.Errorf("盒子ID %q，期望 box-test", hr.Node.Id)
+	}
+	if len(hr.Tasks) != 1 {
+		t.Fatalf("任务数 %d，期望 1", len(hr.Tasks))
+	}
+	task := hr.Tasks[0]
+	if task.NodeID != "box-test" {
+		t.Errorf("任务NodeID %q，期望 box-test", task.NodeID)
+	}
+	if task.ResourceBytes != testResourceCsv {
+		t.Errorf("任务资源 %q，期望 %q", task.ResourceBytes, testResourceCsv)
+	}
+	if n := len(task.GetResources()); n != 2 {
+		t.Errorf("解析资源数 %d，期望 2", n)
+	}
+	beats := c.Heartbeats()
+	if len(beats) != 1 {
+		t.Fatalf("中心收到心跳 %d 次，期望 1", len(beats))
+	}
+	if _, ok := beats[0].Body["serialNumber"]; !ok {
+		t.Error("心跳缺少serialNumber")
+	}
+	if c.Requests(center.PATH_RESOURCE) != 1 {
+		t.Errorf("资源请求 %d 次，期望 1", c.Requests(center.PATH_RESOURCE))
+	}
+}
+
+//本地已有相同资源时不再请求资源
+func TestHttpCenterProxyReuseResource(t *testing.T) {
+	c := newTestCenter()
+	defer c.Close()
+	local := []*model.Task{{ID: "t1", ResourceId: "res1", ResourceBytes: testResourceCsv}}
+	hr, err := newTestProxy().Heart(local, &proxy.HeartReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hr.Tasks[0].ResourceBytes != testResourceCsv {
+		t.Error("未沿用本地资源")
+	}
+	if n := c.Requests(center.PATH_RESOURCE); n != 0 {
+		t.Errorf("资源请求 %d 次，期望 0", n)
+	}
+}
+
+//上报通道
+func TestSubmitChannels(t *testing.T) {
+	c := newTestCenter()
+	defer c.Close()
+	body, _ := json.Marshal(map[string]interface{}{
+		"gid": "34020000001180000001",
+		"channels": []map[string]interface{}{
+			{"channelNo": "34020000001320000001", "name": "c1"},
+		},
+	})
+	err := proxy.Submit(center.PATH_SUBMIT_CHANNEL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs := c.ChannelSubmissions()
+	if len(subs) != 1 {
+		t.Fatalf("中心收到通道上报 %d 次，期望 1", len(subs))
+	}
+	if subs[0].Gid != "34020000001180000001" || len(subs[0].Channels) != 1 {
+		t.Errorf("通道上报内容不符：%+v", subs[0])
+	}
+}
+
+//故障注入：心跳、资源、通道上报失败或中心未确认时返回错误或跳过，恢复后正常
+func TestHttpCenterProxyFailures(t *testing.T) {
+	c := newTestCenter()
+	defer c.Close()
+	hcp := newTestProxy()
+
+	c.Fail(center.PATH_HEART, http.StatusInternalServerError, 1)
+	if _, err := hcp.Heart(nil, &proxy.HeartReport{}); err == nil {
+		t.Error("心跳失败时应返回错误")
+	}
+	hr, err := hcp.Heart(nil, &proxy.HeartReport{})
+	if err != nil {
+		t.Fatalf("心跳恢复后仍失败：%v", err)
+	}
+	if hr.Tasks[0].ResourceBytes == "" {
+		t.Error("心跳恢复后未获取资源")
+	}
+
+	c.SetTasks(&model.Task{ID: "t2", Status: model.TASK_STATUS_RUNNING, ResourceId: "res2", UpdateTime: 2})
+	c.SetResource("res2", testResourceCsv)
+	c.Fail(center.PATH_RESOURCE, http.StatusNotFound, 1)
+	hr, err = hcp.Heart(nil, &proxy.HeartReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hr.Tasks[0].ResourceBytes != "" {
+		t.Error("资源请求失败时不应有资源")
+	}
+
+	c.Fail(center.PATH_SUBMIT_CHANNEL, http.StatusServiceUnavailable, 1)
+	body := []byte(`{"gid":"34020000001180000001","channels":[]}`)
+	if err := proxy.Submit(center.PATH_SUBMIT_CHANNEL, body); err == nil {
+		t.Error("通道上报失败时应返回错误")
+	}
+	if err := proxy.Submit(center.PATH_SUBMIT_CHANNEL, body); err != nil {
+		t.Errorf("通道上报恢复后仍失败：%v", err)
+	}
+
+	c.Reject(center.PATH_SUBMIT_CHANNEL, "500", 1)
+	if err := proxy.Submit(center.PATH_SUBMIT_CHANNEL, body); err != nil {
+		t.Errorf("未配置确认码时http 200即确认：%v", err)
+	}
+
+	viper.Set("center.submitAckCodes", []string{"200"})
+	defer viper.Set("center.submitAckCodes", nil)
+	c.Reject(center.PATH_SUBMIT_CHANNEL, "500", 1)
+	if err := proxy.Submit(center.PATH_SUBMIT_CHANNEL, body); err == nil {
+		t.Error("中心返回非确认码时应返回错误")
+	}
+	for _, ep := range proxy.Centers().Snapshot() {
+		if ep.Fails != 0 {
+			t.Errorf("业务错误码计入了中心故障：%+v", ep)
+		}
+	}
+	if err := proxy.Submit(center.PATH_SUBMIT_CHANNEL, body); err != nil {
+		t.Errorf("业务错误码恢复后仍失败：%v", err)
+	}
+}
+
+//认证失败不计入中心故障，清除凭证后重新注册
+func TestHeartUnauthorized(t *testing.T) {
+	c := newTestCenter()
+	defer c.Close()
+	c.EnableAuth("")
+	viper.Set("sn", "box-auth-test")
+	viper.Set("center.auth.enable", true)
+	defer viper.Set("center.auth.enable", false)
+	hcp := newTestProxy()
+	if _, err := hcp.Heart(nil, &proxy.HeartReport{}); err != nil {
+		t.Fatal(err)
+	}
+	c.SetSecret("box-auth-test", "")
+	if _, err := hcp.Heart(nil, &proxy.HeartReport{}); err != proxy.ErrUnauthorized {
+		t.Fatalf("吊销密钥后返回 %v，期望 ErrUnauthorized", err)
+	}
+	for _, ep := range proxy.Centers().Snapshot() {
+		if ep.Fails != 0 || !ep.Healthy {
+			t.Errorf("认证失败计入了中心故障：%+v", ep)
+		}
+	}
+	if _, err := hcp.Heart(nil, &proxy.HeartReport{}); err != nil {
+		t.Errorf("重新注册后仍失败：%v", err)
+	}
+}
+
+//仅签名所用凭证仍为当前凭证时才清除，过期请求的401不会清除新凭证
+func TestCheckAuthStaleCredential(t *testing.T) {
+	c := newTestCenter()
+	defer c.Close()
+	c.EnableAuth("")
+	viper.Set("sn", "box-auth-stale")
+	viper.Set("center.auth.enable", true)
+	defer viper.Set("center.auth.enable", false)
+	_, current, err := proxy.SignHeader(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provisions := c.Requests(center.PATH_PROVISION)
+
+	stale := &proxy.BoxCredential{SerialNumber: current.SerialNumber, Secret: current.Secret}
+	if err := proxy.CheckAuth(http.StatusUnauthorized, stale); err != proxy.ErrUnauthorized {
+		t.Fatalf("401 返回 %v，期望 ErrUnauthorized", err)
+	}
+	if _, signed, _ := proxy.SignHeader(http.MethodGet, "/", nil); signed != current {
+		t.Error("过期凭证的401清除了当前凭证")
+	}
+	if n := c.Requests(center.PATH_PROVISION); n != provisions {
+		t.Errorf("过期凭证的401触发了重新注册：%d -> %d", provisions, n)
+	}
+
+	proxy.CheckAuth(http.StatusUnauthorized, current)
+	if _, signed, _ := proxy.SignHeader(http.MethodGet, "/", nil); signed == current {
+		t.Error("当前凭证的401未清除凭证")
+	}
+	if n := c.Requests(center.PATH_PROVISION); n != provisions+1 {
+		t.Errorf("当前凭证的401后应重新注册一次：%d -> %d", provisions, n)
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/resilience"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var submitClient *http.Client
+var submitClientOnce sync.Once
+
+//提交数据到中心：POST json到当前中心的path，按中心列表故障切换并经过熔断器
+func Submit(path string, body []byte) error {
+	wrap := &HttpResponseWrapper{}
+	err := resilience.GetBreaker(resilience.ENDPOINT_SUBMIT).Do(func() error {
+		return Centers().Do(func(ep *CenterEndpoint) error {
+			return submit(HttpScheme()+"://"+ep.Address()+path, body, wrap)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	//配置了center.submitAckCodes时，响应体code在其中才算确认；业务错误不切换中心、不计入熔断，由调用方重试
+	ackCodes := viper.GetStringSlice("center.submitAckCodes")
+	if len(ackCodes) == 0 {
+		return nil
+	}
+	for _, code := range ackCodes {
+		if wrap.Code == code {
+			return nil
+		}
+	}
+	return errors.New("中心未确认，code:" + wrap.Code + "，" + wrap.Message)
+}
+
+func submit(url string, body []byte, wrap *HttpResponseWrapper) error {
+	logger.LOG_INFO("http-request:", url)
+	if logger.IsDebug() {
+		logger.LOG_INFO("http-request-params:", string(body))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resilience.Timeout(resilience.ENDPOINT_SUBMIT))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	credential, err := SignRequest(req, body)
+	if err != nil {
+		return err
+	}
+	res, err := getSubmitClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			logger.LOG_WARN("关闭res失败", err)
+		}
+	}()
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := CheckAuth(res.StatusCode, credential); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.New(string(resBytes))
+	}
+	logger.LOG_INFO("http-response:", string(resBytes))
+	err = jsoniter.Unmarshal(resBytes, wrap)
+	if err != nil {
+		return errors.New("中心响应格式错误：" + string(resBytes))
+	}
+	return nil
+}
+
+func getSubmitClient() *http.Client {
+	submitClientOnce.Do(func() {
+		submitClient = &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConns:        20,
+				MaxIdleConnsPerHost: 5,
+				MaxConnsPerHost:     5,
+				IdleConnTimeout:     30 * time.Second,
+				TLSClientConfig:     TLSConfig(),
+			},
+			Timeout: 30 * time.Second,
+		}
+	})
+	return submitClient
+}
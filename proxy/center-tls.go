@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"dyzs/galaxy/logger"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//证书文件检查间隔，文件变更后重新加载（证书轮换）
+const _TLS_RELOAD_INTERVAL = 30 * time.Second
+
+//中心TLS配置：
+//center.tls.enable 开启后心跳、资源、通道上报使用https，预览websocket使用wss
+//center.tls.ca 中心CA证书，为空时使用系统根证书；配置后读取或解析失败时拒绝所有握手
+//center.tls.cert/key 盒子客户端证书（双向认证），路径中的{sn}替换为盒子序列号
+//center.tls.serverName 校验的中心证书名称，为空时使用连接地址
+//center.tls.pins 中心证书公钥sha256指纹（base64或hex），匹配证书链中任意一个即通过
+//center.tls.insecureSkipVerify 不校验证书链（仍校验指纹）
+type centerTLS struct {
+	sync.Mutex
+	checked time.Time
+
+	caFile   string
+	caMod    time.Time
+	roots    *x509.CertPool
+	caErr    error
+	certFile string
+	keyFile  string
+	certMod  time.Time
+	keyMod   time.Time
+	cert     *tls.Certificate
+}
+
+var ctls = &centerTLS{}
+
+//是否开启TLS
+func TLSEnabled() bool {
+	return viper.GetBool("center.tls.enable")
+}
+
+//中心http协议
+func HttpScheme() string {
+	if TLSEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+//中心websocket协议
+func WsScheme() string {
+	if TLSEnabled() {
+		return "wss"
+	}
+	return "ws"
+}
+
+//访问中心的TLS配置，未开启时返回nil；证书和CA在握手时按文件变更重新加载
+func TLSConfig() *tls.Config {
+	if !TLSEnabled() {
+		return nil
+	}
+	//启动时即加载证书，CA异常尽早暴露
+	ctls.reload()
+	return &tls.Config{
+		ServerName: viper.GetString("center.tls.serverName"),
+		//证书链在VerifyConnection中用可轮换的CA校验
+		InsecureSkipVerify:   true,
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: ctls.clientCertificate,
+		VerifyConnection:     ctls.verify,
+	}
+}
+
+func (ct *centerTLS) reload() {
+	ct.Lock()
+	defer ct.Unlock()
+	sn := viper.GetString("sn")
+	caFile := viper.GetString("center.tls.ca")
+	certFile := strings.ReplaceAll(viper.GetString("center.tls.cert"), "{sn}", sn)
+	keyFile := strings.ReplaceAll(viper.GetString("center.tls.key"), "{sn}", sn)
+	if caFile == ct.caFile && certFile == ct.certFile && keyFile == ct.keyFile && time.Since(ct.checked) < _TLS_RELOAD_INTERVAL {
+		return
+	}
+	ct.checked = time.Now()
+	//CA
+	if caFile == "" {
+		ct.roots = nil
+		ct.caFile = ""
+		ct.caErr = nil
+	} else if mod := modTime(caFile); caFile != ct.caFile || !mod.Equal(ct.caMod) {
+		//配置了CA但加载失败时不能回退到系统根证书，文件变更后重试
+		ct.caFile = caFile
+		ct.caMod = mod
+		ct.roots, ct.caErr = loadCA(caFile)
+		if ct.caErr != nil {
+			logger.LOG_ERROR("中心CA证书加载失败，拒绝所有中心TLS握手：", ct.caErr)
+		} else {
+			logger.LOG_INFO("加载中心CA证书：", caFile)
+		}
+	}
+	//客户端证书
+	if certFile == "" || keyFile == "" {
+		ct.cert = nil
+		ct.certFile, ct.keyFile = "", ""
+		return
+	}
+	certMod, keyMod := modTime(certFile), modTime(keyFile)
+	if certFile == ct.certFile && keyFile == ct.keyFile && certMod.Equal(ct.certMod) && keyMod.Equal(ct.keyMod) {
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		logger.LOG_ERROR("加载盒子证书异常，", err)
+		return
+	}
+	ct.cert = &cert
+	ct.certFile, ct.keyFile = certFile, keyFile
+	ct.certMod, ct.keyMod = certMod, keyMod
+	logger.LOG_INFO("加载盒子证书：", certFile)
+}
+
+func (ct *centerTLS) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	ct.reload()
+	ct.Lock()
+	defer ct.Unlock()
+	if ct.cert == nil {
+		//中心要求证书时由中心拒绝握手
+		return &tls.Certificate{}, nil
+	}
+	return ct.cert, nil
+}
+
+func (ct *centerTLS) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("中心未提供证书")
+	}
+	ct.reload()
+	ct.Lock()
+	roots, caErr := ct.roots, ct.caErr
+	ct.Unlock()
+	if caErr != nil {
+		return caErr
+	}
+	if !viper.GetBool("center.tls.insecureSkipVerify") {
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			DNSName:       cs.ServerName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		if err != nil {
+			return err
+		}
+	}
+	pins := viper.GetStringSlice("center.tls.pins")
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, c := range cs.PeerCertificates {
+		sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			pin = strings.TrimSpace(pin)
+			if pin == base64.StdEncoding.EncodeToString(sum[:]) || strings.EqualFold(pin, hex.EncodeToString(sum[:])) {
+				return nil
+			}
+		}
+	}
+	return errors.New("中心证书指纹不匹配")
+}
+
+func loadCA(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.New("读取中心CA证书异常，" + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("中心CA证书格式错误：" + caFile)
+	}
+	return pool, nil
+}
+
+func modTime(file string) time.Time {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
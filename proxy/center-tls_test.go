@@ -0,0 +1,30 @@
+package proxy_test
+
+import (
+	"crypto/tls"
+	"dyzs/galaxy/proxy"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+//配置的CA加载失败时拒绝握手，不回退到系统根证书
+func TestTLSConfigCAFailsClosed(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	viper.Set("center.tls.enable", true)
+	viper.Set("center.tls.insecureSkipVerify", true)
+	viper.Set("center.tls.ca", filepath.Join("testdata", "missing-ca.pem"))
+	defer func() {
+		viper.Set("center.tls.enable", false)
+		viper.Set("center.tls.insecureSkipVerify", false)
+		viper.Set("center.tls.ca", "")
+	}()
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), proxy.TLSConfig())
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("CA加载失败时握手应被拒绝")
+	}
+}
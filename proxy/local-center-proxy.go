@@ -0,0 +1,445 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//清单文件，按顺序查找
+var _MANIFEST_FILES = []string{"manifest.yml", "manifest.yaml", "manifest.json"}
+
+//资源csv目录，文件名为 {resourceId}.csv
+const _MANIFEST_RESOURCE_DIR = "resources"
+
+//文件变更合并间隔
+const _MANIFEST_DEBOUNCE = 500 * time.Millisecond
+
+//本地任务清单
+type Manifest struct {
+	Box   model.Node    `json:"box"`
+	Tasks []*model.Task `json:"tasks"`
+}
+
+//从本地清单目录读取任务和资源，目录变更时通知调度器，用于盒子未接入中心时现场调试
+//清单字段同中心下发的任务；任务未设置status时默认运行，未设置updateTime时取文件修改时间
+type LocalCenterProxy struct {
+	sync.Mutex
+	dir     string
+	changes chan struct{}
+	watcher *fsnotify.Watcher
+	issued  map[string]bool //上次下发的任务
+	nodeId  string          //清单中的盒子ID
+}
+
+func (lcp *LocalCenterProxy) Init() {
+	lcp.dir = viper.GetString("center.local.dir")
+	lcp.changes = make(chan struct{}, 1)
+	lcp.issued = make(map[string]bool)
+	if lcp.dir == "" {
+		logger.LOG_WARN("本地任务清单目录为空")
+		return
+	}
+	err := lcp.watch()
+	if err != nil {
+		logger.LOG_WARN("监听本地任务清单异常，", err)
+	}
+}
+
+//监听清单目录及资源目录
+func (lcp *LocalCenterProxy) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = watcher.Add(lcp.dir)
+	if err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	resourceDir := filepath.Join(lcp.dir, _MANIFEST_RESOURCE_DIR)
+	if _, err := os.Stat(resourceDir); err == nil {
+		err = watcher.Add(resourceDir)
+		if err != nil {
+			logger.LOG_WARN("监听本地资源目录异常，", err)
+		}
+	}
+	lcp.watcher = watcher
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				//资源目录后创建
+				if e.Op&fsnotify.Create != 0 && e.Name == resourceDir {
+					_ = watcher.Add(resourceDir)
+				}
+				timer = time.After(_MANIFEST_DEBOUNCE)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.LOG_WARN("监听本地任务清单异常，", err)
+			case <-timer:
+				timer = nil
+				logger.LOG_WARN("本地任务清单变更")
+				select {
+				case lcp.changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+//停止监听
+func (lcp *LocalCenterProxy) Close() error {
+	if lcp.watcher == nil {
+		return nil
+	}
+	return lcp.watcher.Close()
+}
+
+//清单或资源变更
+func (lcp *LocalCenterProxy) Changes() <-chan struct{} {
+	return lcp.changes
+}
+
+//是否存在清单
+func (lcp *LocalCenterProxy) HasManifest() bool {
+	_, _, err := lcp.manifestFile()
+	return err == nil
+}
+
+func (lcp *LocalCenterProxy) manifestFile() (string, os.FileInfo, error) {
+	if lcp.dir == "" {
+		return "", nil, errors.New("本地任务清单目录为空")
+	}
+	for _, name := range _MANIFEST_FILES {
+		file := filepath.Join(lcp.dir, name)
+		fi, err := os.Stat(file)
+		if err == nil {
+			return file, fi, nil
+		}
+	}
+	return "", nil, errors.New("未找到本地任务清单：" + lcp.dir)
+}
+
+//清单中的任务，yaml按字段类型解析（如 accessType: y 不会被解析为布尔值）
+type manifestTask struct {
+	ID          string      `json:"id" yaml:"id"`
+	Name        string      `json:"name" yaml:"name"`
+	Repository  string      `json:"repository" yaml:"repository"`
+	CurrentTag  string      `json:"currentTag" yaml:"currentTag"`
+	AccessType  string      `json:"accessType" yaml:"accessType"`
+	ExportPorts interface{} `json:"exportPorts" yaml:"exportPorts"`
+	AllResource bool        `json:"allResource" yaml:"allResource"`
+	ResourceId  string      `json:"resourceId" yaml:"resourceId"`
+	Status      *int        `json:"status" yaml:"status"`
+	AccessParam interface{} `json:"accessParam" yaml:"accessParam"`
+	CreateTime  int64       `json:"createTime" yaml:"createTime"`
+	UpdateTime  int64       `json:"updateTime" yaml:"updateTime"`
+}
+
+type manifestFile struct {
+	Box struct {
+		Id string `json:"id" yaml:"id"`
+	} `json:"box" yaml:"box"`
+	Tasks []*manifestTask `json:"tasks" yaml:"tasks"`
+}
+
+//读取清单
+func (lcp *LocalCenterProxy) Load() (*Manifest, error) {
+	file, fi, err := lcp.manifestFile()
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	mf := &manifestFile{}
+	if strings.HasSuffix(file, ".json") {
+		err = json.Unmarshal(content, mf)
+	} else {
+		err = yaml.Unmarshal(content, mf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{Box: model.Node{Id: mf.Box.Id}}
+	if m.Box.Id == "" {
+		m.Box.Id = "local-" + viper.GetString("sn")
+	}
+	lcp.Lock()
+	lcp.nodeId = m.Box.Id
+	lcp.Unlock()
+	for i, mt := range mf.Tasks {
+		if mt.ID == "" {
+			return nil, fmt.Errorf("第%d个任务缺少id", i+1)
+		}
+		t := &model.Task{
+			ID:          mt.ID,
+			Name:        mt.Name,
+			Repository:  mt.Repository,
+			CurrentTag:  mt.CurrentTag,
+			AccessType:  mt.AccessType,
+			AllResource: mt.AllResource,
+			ResourceId:  mt.ResourceId,
+			Status:      model.TASK_STATUS_RUNNING,
+			CreateTime:  mt.CreateTime,
+			UpdateTime:  mt.UpdateTime,
+		}
+		if mt.Status != nil {
+			t.Status = *mt.Status
+		}
+		//accessParam、exportPorts允许直接写对象/数组
+		t.AccessParam, err = jsonString(mt.AccessParam)
+		if err != nil {
+			return nil, fmt.Errorf("第%d个任务accessParam格式错误，%v", i+1, err)
+		}
+		t.ExportPorts, err = jsonString(mt.ExportPorts)
+		if err != nil {
+			return nil, fmt.Errorf("第%d个任务exportPorts格式错误，%v", i+1, err)
+		}
+		if t.UpdateTime == 0 {
+			t.UpdateTime = fi.ModTime().UnixNano() / 1e6
+		}
+		if t.CreateTime == 0 {
+			t.CreateTime = int64(i)
+		}
+		m.Tasks = append(m.Tasks, t)
+	}
+	return m, nil
+}
+
+func jsonString(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(yamlToJson(v))
+	return string(b), err
+}
+
+//yaml.v2的map键为interface{}，转为json可序列化的结构
+func yamlToJson(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[fmt.Sprint(k)] = yamlToJson(v)
+		}
+		return m
+	case []interface{}:
+		for i, v := range x {
+			x[i] = yamlToJson(v)
+		}
+		return x
+	default:
+		return v
+	}
+}
+
+func (lcp *LocalCenterProxy) Heart(localTasks []*model.Task, report *HeartReport) (*HeartResonse, error) {
+	m, err := lcp.Load()
+	if err != nil {
+		return nil, err
+	}
+	lcp.PrepareTasks(localTasks, m.Tasks, m.Box.Id)
+	return &HeartResonse{Node: m.Box, Tasks: lcp.withRemoved(localTasks, m.Tasks, m.Box.Id)}, nil
+}
+
+//清单中删除的任务下发为停止
+func (lcp *LocalCenterProxy) withRemoved(localTasks []*model.Task, tasks []*model.Task, nodeId string) []*model.Task {
+	lcp.Lock()
+	defer lcp.Unlock()
+	tasks = lcp.stopIssued(localTasks, tasks, nodeId)
+	lcp.issued = make(map[string]bool, len(tasks))
+	for _, t := range tasks {
+		if t.Status == model.TASK_STATUS_RUNNING {
+			lcp.issued[t.ID] = true
+		}
+	}
+	return tasks
+}
+
+//中心恢复后，本地清单下发的任务中不在中心任务里的下发为停止
+func (lcp *LocalCenterProxy) Withdraw(localTasks []*model.Task, tasks []*model.Task) []*model.Task {
+	nodeId := lcp.manifestNodeId()
+	lcp.Lock()
+	defer lcp.Unlock()
+	tasks = lcp.stopIssued(localTasks, tasks, nodeId)
+	lcp.issued = make(map[string]bool)
+	return tasks
+}
+
+//不是本地清单下发的任务，用于向中心请求增量任务
+func (lcp *LocalCenterProxy) RemoteTasks(localTasks []*model.Task) []*model.Task {
+	nodeId := lcp.manifestNodeId()
+	lcp.Lock()
+	defer lcp.Unlock()
+	res := make([]*model.Task, 0, len(localTasks))
+	for _, t := range localTasks {
+		if !lcp.owns(t, nodeId) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+//本地清单下发过、但不在tasks中的任务追加为停止，调用方持有锁
+func (lcp *LocalCenterProxy) stopIssued(localTasks []*model.Task, tasks []*model.Task, nodeId string) []*model.Task {
+	ids := make(map[string]bool, len(tasks))
+	for _, t := range tasks {
+		ids[t.ID] = true
+	}
+	for _, t := range localTasks {
+		if lcp.owns(t, nodeId) && !ids[t.ID] {
+			stopped := *t
+			stopped.Status = model.TASK_STATUS_STOP
+			tasks = append(tasks, &stopped)
+		}
+	}
+	return tasks
+}
+
+//是否本地清单下发的任务，重启后按盒子ID识别，调用方持有锁
+func (lcp *LocalCenterProxy) owns(t *model.Task, nodeId string) bool {
+	return lcp.issued[t.ID] || (nodeId != "" && t.NodeID == nodeId)
+}
+
+//清单中的盒子ID，未读取过清单时读取一次，清单不存在时为空
+func (lcp *LocalCenterProxy) manifestNodeId() string {
+	lcp.Lock()
+	nodeId := lcp.nodeId
+	lcp.Unlock()
+	if nodeId != "" || !lcp.HasManifest() {
+		return nodeId
+	}
+	m, err := lcp.Load()
+	if err != nil {
+		return ""
+	}
+	return m.Box.Id
+}
+
+//资源从 resources/{resourceId}.csv 读取，资源编号附加内容摘要，内容变更时调度器按资源变更处理
+func (lcp *LocalCenterProxy) PrepareTasks(localTasks []*model.Task, tasks []*model.Task, nodeId string) {
+	for _, t := range tasks {
+		t.NodeID = nodeId
+		if len(t.ResourceId) == 0 {
+			continue
+		}
+		resourceId := t.ResourceId
+		if idx := strings.Index(resourceId, "@"); idx > 0 {
+			resourceId = resourceId[:idx]
+		}
+		csv, err := ioutil.ReadFile(filepath.Join(lcp.dir, _MANIFEST_RESOURCE_DIR, resourceId+".csv"))
+		if err != nil {
+			logger.LOG_WARN("读取本地任务资源异常，task:", t.ID, "，", err)
+			continue
+		}
+		sum := sha1.Sum(csv)
+		t.ResourceId = resourceId + "@" + hex.EncodeToString(sum[:4])
+		t.ResourceBytes = string(csv)
+	}
+}
+
+//中心与本地清单组合：
+//fallback 中心不可用时使用本地清单（默认），中心恢复后本地清单下发的任务停止
+//merge 本地清单任务追加到中心任务，同ID以中心为准
+//本地清单任务的nodeId为清单中的盒子ID，不计入向中心请求增量任务的时间
+type CombinedCenterProxy struct {
+	Remote CenterProxy
+	Local  *LocalCenterProxy
+	Mode   string
+
+	changes chan struct{}
+}
+
+const (
+	COMBINE_MODE_FALLBACK = "fallback"
+	COMBINE_MODE_MERGE    = "merge"
+)
+
+func (ccp *CombinedCenterProxy) Init() {
+	if ccp.Mode == "" {
+		ccp.Mode = COMBINE_MODE_FALLBACK
+	}
+	ccp.changes = make(chan struct{}, 1)
+	sources := []<-chan struct{}{ccp.Local.Changes()}
+	if notifier, ok := ccp.Remote.(Notifier); ok {
+		sources = append(sources, notifier.Changes())
+	}
+	for _, c := range sources {
+		go func(c <-chan struct{}) {
+			for range c {
+				select {
+				case ccp.changes <- struct{}{}:
+				default:
+				}
+			}
+		}(c)
+	}
+}
+
+func (ccp *CombinedCenterProxy) Changes() <-chan struct{} {
+	return ccp.changes
+}
+
+func (ccp *CombinedCenterProxy) Heart(localTasks []*model.Task, report *HeartReport) (*HeartResonse, error) {
+	hr, err := ccp.Remote.Heart(ccp.Local.RemoteTasks(localTasks), report)
+	if err != nil {
+		if !ccp.Local.HasManifest() {
+			return nil, err
+		}
+		logger.LOG_WARN("中心不可用，使用本地任务清单，", err)
+		return ccp.Local.Heart(localTasks, report)
+	}
+	if ccp.Mode != COMBINE_MODE_MERGE {
+		hr.Tasks = ccp.Local.Withdraw(localTasks, hr.Tasks)
+		return hr, nil
+	}
+	if !ccp.Local.HasManifest() {
+		return hr, nil
+	}
+	lhr, err := ccp.Local.Heart(localTasks, report)
+	if err != nil {
+		logger.LOG_WARN("读取本地任务清单异常，", err)
+		return hr, nil
+	}
+	remote := make(map[string]bool, len(hr.Tasks))
+	for _, t := range hr.Tasks {
+		remote[t.ID] = true
+	}
+	for _, t := range lhr.Tasks {
+		if !remote[t.ID] {
+			hr.Tasks = append(hr.Tasks, t)
+		}
+	}
+	return hr, nil
+}
+
+func (ccp *CombinedCenterProxy) PrepareTasks(localTasks []*model.Task, tasks []*model.Task, nodeId string) {
+	ccp.Remote.PrepareTasks(localTasks, tasks, nodeId)
+}
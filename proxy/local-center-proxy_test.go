@@ -0,0 +1,89 @@
+package proxy_test
+
+import (
+	"dyzs/galaxy/mock/center"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/proxy"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testManifest = `box:
+  id: local-box
+tasks:
+  - id: m1
+    name: m1
+    repository: mock
+`
+
+//中心不可用时使用本地清单，恢复后本地清单任务下发为停止，且不影响增量任务时间
+func TestCombinedCenterProxyFallback(t *testing.T) {
+	c := newTestCenter()
+	defer c.Close()
+	dir, err := ioutil.TempDir("", "galaxy-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "manifest.yml"), []byte(testManifest), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("center.local.dir", dir)
+	defer viper.Set("center.local.dir", "")
+	local := &proxy.LocalCenterProxy{}
+	local.Init()
+	defer local.Close()
+	ccp := &proxy.CombinedCenterProxy{Remote: newTestProxy(), Local: local}
+	ccp.Init()
+
+	//中断前已运行的中心任务
+	remote := &model.Task{ID: "t0", NodeID: "box-test", Status: model.TASK_STATUS_RUNNING, UpdateTime: 5}
+
+	c.Fail(center.PATH_HEART, http.StatusInternalServerError, -1)
+	hr, err := ccp.Heart([]*model.Task{remote}, &proxy.HeartReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hr.Tasks) != 1 || hr.Tasks[0].ID != "m1" || hr.Tasks[0].Status != model.TASK_STATUS_RUNNING {
+		t.Fatalf("中心不可用时应下发清单任务：%+v", hr.Tasks)
+	}
+	manifestTask := hr.Tasks[0]
+	if manifestTask.UpdateTime <= remote.UpdateTime {
+		t.Fatalf("清单任务更新时间 %d 应取文件修改时间", manifestTask.UpdateTime)
+	}
+
+	c.Fail(center.PATH_HEART, 0, 0)
+	hr, err = ccp.Heart([]*model.Task{remote, manifestTask}, &proxy.HeartReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := false
+	for _, task := range hr.Tasks {
+		if task.ID == "m1" {
+			stopped = task.Status == model.TASK_STATUS_STOP
+		}
+	}
+	if !stopped {
+		t.Errorf("中心恢复后清单任务应下发为停止：%+v", hr.Tasks)
+	}
+	beats := c.Heartbeats()
+	if q := beats[len(beats)-1].Query; q != "time=5" {
+		t.Errorf("心跳增量时间 %q，期望 time=5", q)
+	}
+
+	//停止后不再重复下发
+	hr, err = ccp.Heart([]*model.Task{remote}, &proxy.HeartReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range hr.Tasks {
+		if task.ID == "m1" {
+			t.Errorf("清单任务不应再次下发：%+v", task)
+		}
+	}
+}
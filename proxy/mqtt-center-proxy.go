@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"bytes"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"encoding/json"
+	"errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//MQTT主题，{prefix}/box/{sn}/...
+const (
+	_MQTT_TOPIC_HEART     = "heart"     //盒子发布心跳
+	_MQTT_TOPIC_STATUS    = "status"    //盒子在线状态（保留消息，遗嘱为offline）
+	_MQTT_TOPIC_TASKS     = "tasks"     //中心发布任务（保留消息），内容同http心跳响应的data
+	_MQTT_TOPIC_RESOURCE  = "resource/" //中心发布资源csv（保留消息），resource/{resourceId}
+	_MQTT_TOPIC_CMD       = "cmd"       //中心下发指令
+	_MQTT_TOPIC_CMD_REPLY = "cmd/reply" //盒子回复指令
+)
+
+const _MQTT_DEFAULT_PREFIX = "galaxy"
+const _MQTT_WAIT = 3 * time.Second
+
+//MQTT指令，Content同websocket指令内容
+type MqttCommand struct {
+	RequestId string          `json:"requestId"`
+	Content   json.RawMessage `json:"content"`
+}
+
+//MQTT指令回复
+type MqttCommandReply struct {
+	RequestId string          `json:"requestId"`
+	Content   json.RawMessage `json:"content"`
+}
+
+//通过MQTT与中心通信，适用于NAT后的盒子
+type MqttCenterProxy struct {
+	sync.Mutex
+	client mqtt.Client
+	prefix string
+	qos    byte
+
+	tasksPayload []byte
+	tasksC       chan struct{}
+	resources    map[string]string
+	changes      chan struct{}
+	httpClient   *http.Client
+}
+
+func (mcp *MqttCenterProxy) Init() {
+	mcp.prefix = viper.GetString("center.mqtt.topicPrefix")
+	if mcp.prefix == "" {
+		mcp.prefix = _MQTT_DEFAULT_PREFIX
+	}
+	mcp.qos = byte(viper.GetInt("center.mqtt.qos"))
+	if mcp.qos > 1 {
+		mcp.qos = 1
+	}
+	mcp.tasksC = make(chan struct{}, 1)
+	mcp.changes = make(chan struct{}, 1)
+	mcp.resources = make(map[string]string)
+	mcp.httpClient = &http.Client{Timeout: 30 * time.Second}
+
+	opts := mqtt.NewClientOptions()
+	for _, broker := range strings.Split(viper.GetString("center.mqtt.broker"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			opts.AddBroker(broker)
+		}
+	}
+	opts.SetClientID("galaxy-" + viper.GetString("sn"))
+	opts.SetUsername(viper.GetString("center.mqtt.username"))
+	opts.SetPassword(viper.GetString("center.mqtt.password"))
+	if tlsConfig := TLSConfig(); tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectTimeout(5 * time.Second)
+	opts.SetKeepAlive(30 * time.Second)
+	opts.SetWill(mcp.topic(_MQTT_TOPIC_STATUS), "offline", mcp.qos, true)
+	opts.SetOnConnectHandler(mcp.onConnect)
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		logger.LOG_WARN("mqtt连接断开，", err)
+	})
+	mcp.client = mqtt.NewClient(opts)
+}
+
+func (mcp *MqttCenterProxy) topic(name string) string {
+	return mcp.prefix + "/box/" + viper.GetString("sn") + "/" + name
+}
+
+//连接（含重连）后订阅主题，中心的保留消息会重新下发
+func (mcp *MqttCenterProxy) onConnect(c mqtt.Client) {
+	logger.LOG_WARN("mqtt连接成功")
+	c.Publish(mcp.topic(_MQTT_TOPIC_STATUS), mcp.qos, true, "online")
+	subs := map[string]mqtt.MessageHandler{
+		mcp.topic(_MQTT_TOPIC_TASKS):          mcp.onTasks,
+		mcp.topic(_MQTT_TOPIC_RESOURCE + "+"): mcp.onResource,
+		mcp.topic(_MQTT_TOPIC_CMD):            mcp.onCmd,
+	}
+	for topic, handler := range subs {
+		token := c.Subscribe(topic, mcp.qos, handler)
+		if token.WaitTimeout(_MQTT_WAIT) && token.Error() != nil {
+			logger.LOG_WARN("mqtt订阅异常，", topic, "，", token.Error())
+		}
+	}
+}
+
+func (mcp *MqttCenterProxy) onTasks(c mqtt.Client, msg mqtt.Message) {
+	mcp.Lock()
+	changed := !bytes.Equal(mcp.tasksPayload, msg.Payload())
+	mcp.tasksPayload = msg.Payload()
+	mcp.Unlock()
+	select {
+	case mcp.tasksC <- struct{}{}:
+	default:
+	}
+	if changed {
+		mcp.notify()
+	}
+}
+
+func (mcp *MqttCenterProxy) onResource(c mqtt.Client, msg mqtt.Message) {
+	resourceId := strings.TrimPrefix(msg.Topic(), mcp.topic(_MQTT_TOPIC_RESOURCE))
+	mcp.Lock()
+	changed := mcp.resources[resourceId] != string(msg.Payload())
+	mcp.resources[resourceId] = string(msg.Payload())
+	mcp.Unlock()
+	if changed {
+		mcp.notify()
+	}
+}
+
+//中心指令：任务变更通知触发心跳，其他指令转发给galaxy的/cmd
+func (mcp *MqttCenterProxy) onCmd(c mqtt.Client, msg mqtt.Message) {
+	cmd := &MqttCommand{}
+	err := jsoniter.Unmarshal(msg.Payload(), cmd)
+	if err != nil {
+		logger.LOG_WARN("mqtt指令解析异常，", err)
+		return
+	}
+	content := &struct {
+		Cmd    string `json:"Cmd"`
+		Target string `json:"Target"`
+	}{}
+	_ = jsoniter.Unmarshal(cmd.Content, content)
+	if content.Cmd == "TasksChanged" {
+		mcp.notify()
+		mcp.reply(cmd.RequestId, []byte(`{"Code":200,"Message":"success"}`))
+		return
+	}
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:"+viper.GetString("port")+"/cmd", bytes.NewReader(cmd.Content))
+		if err != nil {
+			logger.LOG_WARN("mqtt指令转发异常，", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Target", content.Target)
+		res, err := mcp.httpClient.Do(req)
+		if err != nil {
+			logger.LOG_WARN("mqtt指令转发异常，", err)
+			return
+		}
+		defer func() {
+			err := res.Body.Close()
+			if err != nil {
+				logger.LOG_WARN("关闭res失败", err)
+			}
+		}()
+		resBytes, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			logger.LOG_WARN("mqtt指令响应读取异常，", err)
+			return
+		}
+		mcp.reply(cmd.RequestId, resBytes)
+	}()
+}
+
+func (mcp *MqttCenterProxy) reply(requestId string, content []byte) {
+	if !json.Valid(content) {
+		content, _ = json.Marshal(string(content))
+	}
+	payload, _ := json.Marshal(&MqttCommandReply{RequestId: requestId, Content: content})
+	mcp.client.Publish(mcp.topic(_MQTT_TOPIC_CMD_REPLY), mcp.qos, false, payload)
+}
+
+func (mcp *MqttCenterProxy) notify() {
+	select {
+	case mcp.changes <- struct{}{}:
+	default:
+	}
+}
+
+//中心下发的任务/资源变更
+func (mcp *MqttCenterProxy) Changes() <-chan struct{} {
+	return mcp.changes
+}
+
+func (mcp *MqttCenterProxy) connect() error {
+	if mcp.client.IsConnected() {
+		return nil
+	}
+	token := mcp.client.Connect()
+	if !token.WaitTimeout(_MQTT_WAIT) {
+		return errors.New("mqtt连接超时")
+	}
+	return token.Error()
+}
+
+func (mcp *MqttCenterProxy) Heart(localTasks []*model.Task, report *HeartReport) (*HeartResonse, error) {
+	err := mcp.connect()
+	if err != nil {
+		return nil, err
+	}
+	var lastUpdateTime int64
+	for _, v := range localTasks {
+		if v.UpdateTime > lastUpdateTime {
+			lastUpdateTime = v.UpdateTime
+		}
+	}
+	body := generateHeartBody(report)
+	body["time"] = lastUpdateTime
+	payload, _ := json.Marshal(body)
+	token := mcp.client.Publish(mcp.topic(_MQTT_TOPIC_HEART), mcp.qos, false, payload)
+	if !token.WaitTimeout(_MQTT_WAIT) {
+		return nil, errors.New("mqtt心跳发布超时")
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+	//等待任务保留消息
+	mcp.Lock()
+	tasksPayload := mcp.tasksPayload
+	mcp.Unlock()
+	if tasksPayload == nil {
+		select {
+		case <-mcp.tasksC:
+		case <-time.After(_MQTT_WAIT):
+			return nil, errors.New("未收到中心任务消息")
+		}
+		mcp.Lock()
+		tasksPayload = mcp.tasksPayload
+		mcp.Unlock()
+	}
+	hr := &HeartResonse{}
+	err = jsoniter.Unmarshal(tasksPayload, hr)
+	if err != nil {
+		return nil, err
+	}
+	mcp.PrepareTasks(localTasks, hr.Tasks, hr.Node.Id)
+	return hr, nil
+}
+
+//资源从保留消息中获取
+func (mcp *MqttCenterProxy) PrepareTasks(localTasks []*model.Task, tasks []*model.Task, nodeId string) {
+	mcp.Lock()
+	defer mcp.Unlock()
+	for _, t := range tasks {
+		t.NodeID = nodeId
+		if len(t.ResourceId) == 0 {
+			continue
+		}
+		if csv, ok := mcp.resources[t.ResourceId]; ok {
+			t.ResourceBytes = csv
+		} else {
+			logger.LOG_WARN("未收到任务资源，task:", t.ID, "，resourceId:", t.ResourceId)
+		}
+	}
+}
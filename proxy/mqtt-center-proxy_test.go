@@ -0,0 +1,117 @@
+package proxy_test
+
+import (
+	"dyzs/galaxy/mock/broker"
+	"dyzs/galaxy/proxy"
+	"encoding/json"
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testMqttSn     = "mqtt-test"
+	testMqttPrefix = "galaxy/box/" + testMqttSn + "/"
+)
+
+func startTestBroker(t *testing.T) *broker.Broker {
+	b, err := broker.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("sn", testMqttSn)
+	viper.Set("center.mqtt.broker", b.URL())
+	viper.Set("center.mqtt.topicPrefix", "galaxy")
+	viper.Set("center.mqtt.qos", 1)
+	return b
+}
+
+func tasksPayload(updateTime int64) []byte {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"box": map[string]interface{}{"id": "box-mqtt"},
+		"tasks": []map[string]interface{}{
+			{"id": "t1", "status": 1, "resourceId": "res1", "updateTime": updateTime},
+		},
+	})
+	return payload
+}
+
+//等待客户端发布到topic的消息
+func waitPublished(t *testing.T, b *broker.Broker, topic, contains string) *broker.Message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, m := range b.Published() {
+			if m.Topic == topic && strings.Contains(string(m.Payload), contains) {
+				return m
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("未收到消息：%s %s", topic, contains)
+	return nil
+}
+
+//心跳发布到broker，任务和资源从中心的保留消息获取；任务变更、指令和遗嘱
+func TestMqttCenterProxy(t *testing.T) {
+	b := startTestBroker(t)
+	defer b.Close()
+	b.Publish(testMqttPrefix+"tasks", tasksPayload(1), true)
+	b.Publish(testMqttPrefix+"resource/res1", []byte(testResourceCsv), true)
+
+	mcp := &proxy.MqttCenterProxy{}
+	mcp.Init()
+
+	//订阅在连接后异步完成，资源消息可能晚于任务消息到达
+	var hr *proxy.HeartResonse
+	var err error
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hr, err = mcp.Heart(nil, &proxy.HeartReport{})
+		if err == nil && len(hr.Tasks) == 1 && hr.Tasks[0].ResourceBytes != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("心跳未获取到任务和资源：%v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if hr.Node.Id != "box-mqtt" || hr.Tasks[0].NodeID != "box-mqtt" {
+		t.Errorf("盒子ID不符：%q %q", hr.Node.Id, hr.Tasks[0].NodeID)
+	}
+	if hr.Tasks[0].ResourceBytes != testResourceCsv {
+		t.Errorf("任务资源 %q，期望 %q", hr.Tasks[0].ResourceBytes, testResourceCsv)
+	}
+	waitPublished(t, b, testMqttPrefix+"heart", "serialNumber")
+	waitPublished(t, b, testMqttPrefix+"status", "online")
+
+	t.Run("tasksChanged", func(t *testing.T) {
+		for len(mcp.Changes()) > 0 {
+			<-mcp.Changes()
+		}
+		b.Publish(testMqttPrefix+"tasks", tasksPayload(2), true)
+		select {
+		case <-mcp.Changes():
+		case <-time.After(5 * time.Second):
+			t.Fatal("任务变更未通知")
+		}
+		hr, err := mcp.Heart(nil, &proxy.HeartReport{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hr.Tasks[0].UpdateTime != 2 {
+			t.Errorf("任务更新时间 %d，期望 2", hr.Tasks[0].UpdateTime)
+		}
+	})
+
+	t.Run("cmdReply", func(t *testing.T) {
+		b.Publish(testMqttPrefix+"cmd", []byte(`{"requestId":"req-1","content":{"Cmd":"TasksChanged"}}`), false)
+		waitPublished(t, b, testMqttPrefix+"cmd/reply", "req-1")
+	})
+
+	t.Run("will", func(t *testing.T) {
+		b.Disconnect("galaxy-" + testMqttSn)
+		waitPublished(t, b, testMqttPrefix+"status", "offline")
+	})
+}
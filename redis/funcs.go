@@ -164,6 +164,29 @@ func (c Cache) HGet(name, field string, v interface{}) (err error) {
 	return
 }
 
+// 获取hash 中所有的键值
+func (c Cache) HGetAll(name string) (map[string][]byte, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	values, err := redis.Values(conn.Do("HGETALL", name))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string][]byte, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		key, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		value, err := redis.Bytes(values[i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+		res[key] = value
+	}
+	return res, nil
+}
+
 // set 集合
 
 // 获取 set 集合中所有的元素, 想要什么类型的自己指定
@@ -0,0 +1,122 @@
+//中心通信的容错：指数退避（带抖动）、熔断器、按接口的超时
+package resilience
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//指数退避，第n次失败后等待 Base*Factor^(n-1)，不超过Max，再加减Jitter比例的随机抖动
+type Backoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Factor float64
+	Jitter float64
+}
+
+//第attempt次失败后的等待时间，attempt从1开始
+func (b *Backoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	factor := b.Factor
+	if factor < 1 {
+		factor = 2
+	}
+	d := float64(b.Base) * math.Pow(factor, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+type keyedState struct {
+	attempts int
+	next     time.Time
+}
+
+//按键退避，用于单个资源等重复请求的失败间隔
+type KeyedBackoff struct {
+	sync.Mutex
+	backoff *Backoff
+	states  map[string]*keyedState
+}
+
+func NewKeyedBackoff(backoff *Backoff) *KeyedBackoff {
+	return &KeyedBackoff{
+		backoff: backoff,
+		states:  make(map[string]*keyedState),
+	}
+}
+
+//是否可以请求
+func (kb *KeyedBackoff) Ready(key string) bool {
+	kb.Lock()
+	defer kb.Unlock()
+	s, ok := kb.states[key]
+	return !ok || !time.Now().Before(s.next)
+}
+
+//记录失败，返回下次可请求的时间
+func (kb *KeyedBackoff) Failure(key string) time.Time {
+	kb.Lock()
+	defer kb.Unlock()
+	s, ok := kb.states[key]
+	if !ok {
+		s = &keyedState{}
+		kb.states[key] = s
+	}
+	s.attempts++
+	s.next = time.Now().Add(kb.backoff.Next(s.attempts))
+	return s.next
+}
+
+func (kb *KeyedBackoff) Success(key string) {
+	kb.Lock()
+	defer kb.Unlock()
+	delete(kb.states, key)
+}
+
+//退避中的键数量
+func (kb *KeyedBackoff) Pending() int {
+	kb.Lock()
+	defer kb.Unlock()
+	return len(kb.states)
+}
+
+var (
+	keyedLock sync.Mutex
+	keyed     = make(map[string]*KeyedBackoff)
+)
+
+//创建按键退避并登记，用于状态查看
+func NamedKeyedBackoff(name string, backoff *Backoff) *KeyedBackoff {
+	kb := NewKeyedBackoff(backoff)
+	keyedLock.Lock()
+	keyed[name] = kb
+	keyedLock.Unlock()
+	return kb
+}
+
+//各按键退避中的数量
+func KeyedPending() map[string]int {
+	keyedLock.Lock()
+	list := make(map[string]*KeyedBackoff, len(keyed))
+	for k, v := range keyed {
+		list[k] = v
+	}
+	keyedLock.Unlock()
+	res := make(map[string]int, len(list))
+	for k, v := range list {
+		res[k] = v.Pending()
+	}
+	return res
+}
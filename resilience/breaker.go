@@ -0,0 +1,186 @@
+package resilience
+
+import (
+	"dyzs/galaxy/logger"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	STATE_CLOSED    = "closed"
+	STATE_OPEN      = "open"
+	STATE_HALF_OPEN = "halfOpen"
+)
+
+var ErrBreakerOpen = errors.New("熔断中")
+
+//熔断器：连续失败Threshold次后打开，OpenTimeout后半开放行一次探测，成功则关闭
+type Breaker struct {
+	sync.Mutex
+	name        string
+	threshold   int
+	openTimeout time.Duration
+
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+	total     int64
+	failed    int64
+	rejected  int64
+}
+
+//熔断器状态
+type BreakerSnapshot struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"` //连续失败次数
+	OpenedAt  time.Time `json:"openedAt,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+	Total     int64     `json:"total"`
+	Failed    int64     `json:"failed"`
+	Rejected  int64     `json:"rejected"` //熔断拒绝的请求数
+}
+
+func NewBreaker(name string, threshold int, openTimeout time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &Breaker{
+		name:        name,
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		state:       STATE_CLOSED,
+	}
+}
+
+//是否放行，打开时返回ErrBreakerOpen
+func (b *Breaker) Allow() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == STATE_OPEN && time.Since(b.openedAt) >= b.openTimeout {
+		b.transition(STATE_HALF_OPEN)
+	}
+	switch b.state {
+	case STATE_OPEN:
+		b.rejected++
+		return ErrBreakerOpen
+	case STATE_HALF_OPEN:
+		//半开时只放行一个探测请求
+		if b.probing {
+			b.rejected++
+			return ErrBreakerOpen
+		}
+		b.probing = true
+	}
+	b.total++
+	return nil
+}
+
+//记录请求结果
+func (b *Breaker) Done(err error) {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		if b.state != STATE_CLOSED {
+			b.transition(STATE_CLOSED)
+		}
+		return
+	}
+	b.failed++
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == STATE_HALF_OPEN || (b.state == STATE_CLOSED && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.transition(STATE_OPEN)
+	}
+}
+
+//执行fn，熔断时直接返回ErrBreakerOpen
+func (b *Breaker) Do(fn func() error) error {
+	err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	b.Done(err)
+	return err
+}
+
+func (b *Breaker) State() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+func (b *Breaker) Snapshot() *BreakerSnapshot {
+	b.Lock()
+	defer b.Unlock()
+	return &BreakerSnapshot{
+		Name:      b.name,
+		State:     b.state,
+		Failures:  b.failures,
+		OpenedAt:  b.openedAt,
+		LastError: b.lastError,
+		Total:     b.total,
+		Failed:    b.failed,
+		Rejected:  b.rejected,
+	}
+}
+
+//状态变化时记录日志，单次失败不记录
+func (b *Breaker) transition(state string) {
+	old := b.state
+	b.state = state
+	switch state {
+	case STATE_OPEN:
+		logger.LOG_WARN("熔断打开：", b.name, "，连续失败", b.failures, "次，", b.lastError)
+	case STATE_HALF_OPEN:
+		logger.LOG_INFO("熔断半开：", b.name)
+	case STATE_CLOSED:
+		if old != STATE_CLOSED {
+			logger.LOG_WARN("熔断恢复：", b.name)
+		}
+	}
+}
+
+var (
+	breakersLock sync.Mutex
+	breakers     = make(map[string]*Breaker)
+)
+
+//按名称获取共享熔断器，不存在时按配置创建
+func GetBreaker(name string) *Breaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		threshold, openTimeout := breakerConfig()
+		b = NewBreaker(name, threshold, openTimeout)
+		breakers[name] = b
+	}
+	return b
+}
+
+//所有熔断器状态
+func Breakers() []*BreakerSnapshot {
+	breakersLock.Lock()
+	list := make([]*Breaker, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, b)
+	}
+	breakersLock.Unlock()
+	res := make([]*BreakerSnapshot, 0, len(list))
+	for _, b := range list {
+		res = append(res, b.Snapshot())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
@@ -0,0 +1,72 @@
+package resilience
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+//中心接口
+const (
+	ENDPOINT_HEART     = "center.heart"
+	ENDPOINT_RESOURCE  = "center.resource"
+	ENDPOINT_SUBMIT    = "center.submit"
+	ENDPOINT_PROVISION = "center.provision"
+)
+
+//默认超时
+var _DEFAULT_TIMEOUTS = map[string]time.Duration{
+	ENDPOINT_HEART:     5 * time.Second,
+	ENDPOINT_RESOURCE:  30 * time.Second,
+	ENDPOINT_SUBMIT:    10 * time.Second,
+	ENDPOINT_PROVISION: 10 * time.Second,
+}
+
+const _DEFAULT_TIMEOUT = 10 * time.Second
+
+//接口超时，配置 resilience.timeouts.{heart|resource|submit|provision}
+func Timeout(endpoint string) time.Duration {
+	if d := viper.GetDuration("resilience.timeouts." + strings.TrimPrefix(endpoint, "center.")); d > 0 {
+		return d
+	}
+	if d, ok := _DEFAULT_TIMEOUTS[endpoint]; ok {
+		return d
+	}
+	return _DEFAULT_TIMEOUT
+}
+
+//退避配置，resilience.backoff.{base|max|factor|jitter}，base未配置时使用defaultBase
+func NewBackoff(defaultBase time.Duration) *Backoff {
+	b := &Backoff{
+		Base:   viper.GetDuration("resilience.backoff.base"),
+		Max:    viper.GetDuration("resilience.backoff.max"),
+		Factor: viper.GetFloat64("resilience.backoff.factor"),
+		Jitter: 0.2,
+	}
+	if b.Base <= 0 {
+		b.Base = defaultBase
+	}
+	if b.Max <= 0 {
+		b.Max = 5 * time.Minute
+	}
+	if b.Factor < 1 {
+		b.Factor = 2
+	}
+	if viper.IsSet("resilience.backoff.jitter") {
+		b.Jitter = viper.GetFloat64("resilience.backoff.jitter")
+	}
+	return b
+}
+
+//熔断配置，resilience.breaker.{threshold|openTimeout}
+func breakerConfig() (int, time.Duration) {
+	threshold := viper.GetInt("resilience.breaker.threshold")
+	if threshold <= 0 {
+		threshold = 5
+	}
+	openTimeout := viper.GetDuration("resilience.breaker.openTimeout")
+	if openTimeout <= 0 {
+		openTimeout = 30 * time.Second
+	}
+	return threshold, openTimeout
+}
@@ -0,0 +1,316 @@
+package router
+
+import (
+	"dyzs/galaxy/constants"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/redis"
+	"sync"
+	"time"
+)
+
+//资源分配到多个任务时的选择策略
+const (
+	POLICY_FAILOVER   = "failover"   //主备：优先第一个健康的任务
+	POLICY_ROUNDROBIN = "roundrobin" //轮询健康的任务
+	POLICY_REJECT     = "reject"     //拒绝路由
+)
+
+//路由表快照，持久化到redis，重启后先用快照路由，待任务重新init后刷新
+type Snapshot struct {
+	Routes     map[string][]string `json:"routes"` //资源编号/国标编码/通道编号 -> 任务ID
+	Ports      map[string]int      `json:"ports"`  //任务ID -> 管理端口
+	UpdateTime int64               `json:"updateTime"`
+}
+
+//资源到任务的路由表，并发安全
+type Table struct {
+	sync.RWMutex
+	address     func(taskId string, port int) string
+	routes      map[string][]string
+	ports       map[string]int
+	updateTime  int64
+	redisClient *redis.Cache
+	persistLock sync.Mutex
+	restored    map[string][]string //快照中的路由，任务重新init前沿用
+	pending     map[string]bool     //尚未重新init、沿用快照路由的任务
+
+	policy    string
+	unhealthy map[string]bool
+	conflicts []*model.RouteConflict
+	rrLock    sync.Mutex
+	rrCounter map[string]int
+}
+
+//address为任务管理地址的生成方法，redisClient为空时不持久化，policy为冲突策略
+func NewTable(address func(taskId string, port int) string, redisClient *redis.Cache, policy string) *Table {
+	switch policy {
+	case POLICY_FAILOVER, POLICY_ROUNDROBIN, POLICY_REJECT:
+	default:
+		if policy != "" {
+			logger.LOG_WARN("未知的路由冲突策略：", policy, "，使用", POLICY_FAILOVER)
+		}
+		policy = POLICY_FAILOVER
+	}
+	return &Table{
+		address:     address,
+		routes:      make(map[string][]string),
+		ports:       make(map[string]int),
+		redisClient: redisClient,
+		policy:      policy,
+		unhealthy:   make(map[string]bool),
+		rrCounter:   make(map[string]int),
+		pending:     make(map[string]bool),
+	}
+}
+
+//从redis加载快照
+func (t *Table) Load() {
+	if t.redisClient == nil {
+		return
+	}
+	snapshot := &Snapshot{}
+	err := t.redisClient.StringGet(constants.REDIS_KEY_ROUTES, snapshot)
+	if err != nil {
+		logger.LOG_WARN("从redis获取路由表失败", err)
+		return
+	}
+	t.restore(snapshot)
+	logger.LOG_INFO("从redis加载路由表，路由数：", len(snapshot.Routes), "，任务数：", len(snapshot.Ports))
+}
+
+//恢复快照，快照中的任务在重新init前沿用快照路由
+func (t *Table) restore(snapshot *Snapshot) {
+	t.Lock()
+	defer t.Unlock()
+	if snapshot.Routes != nil {
+		t.routes = snapshot.Routes
+		t.restored = make(map[string][]string, len(snapshot.Routes))
+		for key, taskIds := range snapshot.Routes {
+			t.restored[key] = append([]string(nil), taskIds...)
+			for _, id := range taskIds {
+				t.pending[id] = true
+			}
+		}
+	}
+	if snapshot.Ports != nil {
+		t.ports = snapshot.Ports
+	}
+	t.updateTime = snapshot.UpdateTime
+}
+
+//重建路由，taskIds为当前全部任务，同一编号的任务按主备顺序排列；
+//尚未重新init的任务沿用快照中的路由，已删除任务的快照路由和管理端口被清除
+func (t *Table) Rebuild(routes map[string][]string, taskIds []string) {
+	live := make(map[string]bool, len(taskIds))
+	for _, id := range taskIds {
+		live[id] = true
+	}
+	t.Lock()
+	for id := range t.pending {
+		if !live[id] {
+			delete(t.pending, id)
+		}
+	}
+	for id := range t.ports {
+		if !live[id] {
+			delete(t.ports, id)
+			delete(t.unhealthy, id)
+		}
+	}
+	if len(t.pending) == 0 {
+		t.restored = nil
+	} else {
+		routes = t.mergeRestored(routes)
+	}
+	var conflicts []*model.RouteConflict
+	for key, ids := range routes {
+		if len(ids) > 1 {
+			conflicts = append(conflicts, &model.RouteConflict{
+				Key:     key,
+				TaskIds: ids,
+				Policy:  t.policy,
+			})
+		}
+	}
+	t.routes = routes
+	t.conflicts = conflicts
+	t.Unlock()
+	if len(conflicts) > 0 {
+		logger.LOG_WARN("资源下发到了多个任务，冲突数：", len(conflicts), "，策略：", t.policy)
+	}
+	t.persist()
+}
+
+//任务已重新init并声明路由，不再沿用快照，下次重建时生效
+func (t *Table) Settle(taskId string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.pending, taskId)
+	if len(t.pending) == 0 {
+		t.restored = nil
+	}
+}
+
+//用快照路由替换沿用快照的任务的路由
+func (t *Table) mergeRestored(routes map[string][]string) map[string][]string {
+	merged := make(map[string][]string, len(routes))
+	for key, ids := range routes {
+		for _, id := range ids {
+			if !t.pending[id] {
+				merged[key] = append(merged[key], id)
+			}
+		}
+	}
+	for key, ids := range t.restored {
+		for _, id := range ids {
+			if t.pending[id] && !contains(merged[key], id) {
+				merged[key] = append(merged[key], id)
+			}
+		}
+	}
+	return merged
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+//更新任务健康状态
+func (t *Table) SetHealth(taskId string, healthy bool) {
+	t.Lock()
+	defer t.Unlock()
+	if healthy {
+		delete(t.unhealthy, taskId)
+	} else {
+		t.unhealthy[taskId] = true
+	}
+}
+
+//当前的资源冲突
+func (t *Table) Conflicts() []*model.RouteConflict {
+	t.RLock()
+	defer t.RUnlock()
+	return t.conflicts
+}
+
+//记录任务管理端口
+func (t *Table) SetPort(taskId string, port int) {
+	t.Lock()
+	t.ports[taskId] = port
+	t.Unlock()
+	t.persist()
+}
+
+//移除任务管理端口
+func (t *Table) RemovePort(taskId string) {
+	t.Lock()
+	delete(t.ports, taskId)
+	delete(t.unhealthy, taskId)
+	t.Unlock()
+	t.persist()
+}
+
+//任务管理端口，不存在返回0
+func (t *Table) Port(taskId string) int {
+	t.RLock()
+	defer t.RUnlock()
+	return t.ports[taskId]
+}
+
+//查找编号对应的任务
+func (t *Table) Lookup(key string) []string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.routes[key]
+}
+
+//查找编号对应的任务地址
+func (t *Table) Address(key string) string {
+	t.RLock()
+	defer t.RUnlock()
+	taskId := t.choose(key, t.routes[key])
+	if taskId == "" {
+		return ""
+	}
+	port, ok := t.ports[taskId]
+	if !ok {
+		return ""
+	}
+	return t.address(taskId, port)
+}
+
+//按冲突策略选择任务
+func (t *Table) choose(key string, taskIds []string) string {
+	if len(taskIds) == 0 {
+		return ""
+	}
+	if len(taskIds) == 1 {
+		return taskIds[0]
+	}
+	if t.policy == POLICY_REJECT {
+		logger.LOG_WARN("资源下发到了多个任务，拒绝路由，资源ID：", key, ";任务Ids：", taskIds)
+		return ""
+	}
+	var healthy []string
+	for _, id := range taskIds {
+		if !t.unhealthy[id] {
+			healthy = append(healthy, id)
+		}
+	}
+	//全部不健康时退回主任务
+	if len(healthy) == 0 {
+		return taskIds[0]
+	}
+	if t.policy == POLICY_ROUNDROBIN {
+		t.rrLock.Lock()
+		i := t.rrCounter[key] % len(healthy)
+		t.rrCounter[key] = i + 1
+		t.rrLock.Unlock()
+		return healthy[i]
+	}
+	return healthy[0]
+}
+
+//获取快照（拷贝）
+func (t *Table) Snapshot() *Snapshot {
+	t.RLock()
+	defer t.RUnlock()
+	return t.snapshot()
+}
+
+func (t *Table) snapshot() *Snapshot {
+	s := &Snapshot{
+		Routes:     make(map[string][]string, len(t.routes)),
+		Ports:      make(map[string]int, len(t.ports)),
+		UpdateTime: t.updateTime,
+	}
+	for k, v := range t.routes {
+		s.Routes[k] = append([]string(nil), v...)
+	}
+	for k, v := range t.ports {
+		s.Ports[k] = v
+	}
+	return s
+}
+
+func (t *Table) persist() {
+	t.persistLock.Lock()
+	defer t.persistLock.Unlock()
+	t.Lock()
+	t.updateTime = time.Now().UnixNano() / 1e6
+	s := t.snapshot()
+	t.Unlock()
+	if t.redisClient == nil {
+		return
+	}
+	err := t.redisClient.StringSet(constants.REDIS_KEY_ROUTES, s)
+	if err != nil {
+		logger.LOG_ERROR("路由表缓存入redis异常，", err)
+	}
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func testAddress(taskId string, port int) string {
+	return taskId + ":" + strconv.Itoa(port)
+}
+
+func TestConflictPolicy(t *testing.T) {
+	cases := []struct {
+		name      string
+		policy    string
+		taskIds   []string
+		unhealthy []string
+		want      []string //连续多次路由的结果
+	}{
+		{"主备", POLICY_FAILOVER, []string{"a", "b"}, nil, []string{"a:1", "a:1", "a:1"}},
+		{"主备-主任务不健康", POLICY_FAILOVER, []string{"a", "b"}, []string{"a"}, []string{"b:2", "b:2"}},
+		{"主备-全部不健康退回主任务", POLICY_FAILOVER, []string{"a", "b"}, []string{"a", "b"}, []string{"a:1"}},
+		{"未知策略按主备", "unknown", []string{"a", "b"}, nil, []string{"a:1", "a:1"}},
+		{"默认策略按主备", "", []string{"a", "b"}, nil, []string{"a:1"}},
+		{"轮询", POLICY_ROUNDROBIN, []string{"a", "b", "c"}, nil, []string{"a:1", "b:2", "c:3", "a:1"}},
+		{"轮询-跳过不健康", POLICY_ROUNDROBIN, []string{"a", "b", "c"}, []string{"b"}, []string{"a:1", "c:3", "a:1"}},
+		{"拒绝", POLICY_REJECT, []string{"a", "b"}, nil, []string{"", ""}},
+		{"拒绝-无冲突正常路由", POLICY_REJECT, []string{"a"}, nil, []string{"a:1", "a:1"}},
+	}
+	for _, c := range cases {
+		table := NewTable(testAddress, nil, c.policy)
+		for i, id := range c.taskIds {
+			table.SetPort(id, i+1)
+		}
+		for _, id := range c.unhealthy {
+			table.SetHealth(id, false)
+		}
+		table.Rebuild(map[string][]string{"r1": c.taskIds}, c.taskIds)
+		for i, want := range c.want {
+			if got := table.Address("r1"); got != want {
+				t.Errorf("%s：第%d次路由到%q，期望%q", c.name, i+1, got, want)
+			}
+		}
+		conflicts := table.Conflicts()
+		if len(c.taskIds) > 1 {
+			if len(conflicts) != 1 || conflicts[0].Key != "r1" || conflicts[0].Policy != table.policy {
+				t.Errorf("%s：冲突上报错误：%+v", c.name, conflicts)
+			}
+		} else if len(conflicts) != 0 {
+			t.Errorf("%s：无冲突时上报了冲突：%+v", c.name, conflicts)
+		}
+	}
+}
+
+//快照恢复后沿用快照路由，任务重新init（Settle）后使用新路由，任务删除后清除快照路由
+func TestRestoreAndSettle(t *testing.T) {
+	table := NewTable(testAddress, nil, POLICY_FAILOVER)
+	table.restore(&Snapshot{
+		Routes: map[string][]string{"r1": {"a"}, "r2": {"b"}, "r3": {"b"}},
+		Ports:  map[string]int{"a": 1, "b": 2},
+	})
+	if got := table.Address("r1"); got != "a:1" {
+		t.Fatalf("恢复快照后路由到%q", got)
+	}
+
+	steps := []struct {
+		name    string
+		settle  []string
+		routes  map[string][]string
+		taskIds []string
+		want    map[string][]string
+		ports   map[string]int
+	}{
+		{
+			name:    "任务未重新init时沿用快照",
+			routes:  map[string][]string{},
+			taskIds: []string{"a", "b"},
+			want:    map[string][]string{"r1": {"a"}, "r2": {"b"}, "r3": {"b"}},
+			ports:   map[string]int{"a": 1, "b": 2},
+		},
+		{
+			name:    "未Settle的任务忽略新声明",
+			routes:  map[string][]string{"r4": {"a"}},
+			taskIds: []string{"a", "b"},
+			want:    map[string][]string{"r1": {"a"}, "r2": {"b"}, "r3": {"b"}},
+			ports:   map[string]int{"a": 1, "b": 2},
+		},
+		{
+			name:    "Settle后使用新路由，其他任务仍沿用快照",
+			settle:  []string{"a"},
+			routes:  map[string][]string{"r3": {"a"}, "r4": {"a"}},
+			taskIds: []string{"a", "b"},
+			want:    map[string][]string{"r2": {"b"}, "r3": {"a", "b"}, "r4": {"a"}},
+			ports:   map[string]int{"a": 1, "b": 2},
+		},
+		{
+			name:    "任务删除后清除快照路由和端口",
+			routes:  map[string][]string{"r3": {"a"}, "r4": {"a"}},
+			taskIds: []string{"a"},
+			want:    map[string][]string{"r3": {"a"}, "r4": {"a"}},
+			ports:   map[string]int{"a": 1},
+		},
+	}
+	for _, s := range steps {
+		for _, id := range s.settle {
+			table.Settle(id)
+		}
+		table.Rebuild(s.routes, s.taskIds)
+		snapshot := table.Snapshot()
+		if !reflect.DeepEqual(snapshot.Routes, s.want) {
+			t.Errorf("%s：路由%v，期望%v", s.name, snapshot.Routes, s.want)
+		}
+		if !reflect.DeepEqual(snapshot.Ports, s.ports) {
+			t.Errorf("%s：端口%v，期望%v", s.name, snapshot.Ports, s.ports)
+		}
+	}
+	table.RLock()
+	defer table.RUnlock()
+	if len(table.pending) != 0 || table.restored != nil {
+		t.Errorf("全部任务重新init或删除后仍沿用快照：%v %v", table.pending, table.restored)
+	}
+}
@@ -0,0 +1,101 @@
+package schema
+
+import (
+	"dyzs/galaxy/logger"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+//接入类型 -> 接入参数schema
+var registry = make(map[string]*Schema)
+var registryLock sync.RWMutex
+
+//注册接入类型的参数schema
+func Register(accessType string, raw []byte) error {
+	s := &Schema{}
+	err := json.Unmarshal(raw, s)
+	if err != nil {
+		return fmt.Errorf("schema解析异常，accessType:%s，%v", accessType, err)
+	}
+	err = s.compile()
+	if err != nil {
+		return fmt.Errorf("schema编译异常，accessType:%s，%v", accessType, err)
+	}
+	registryLock.Lock()
+	registry[accessType] = s
+	registryLock.Unlock()
+	return nil
+}
+
+//从目录加载schema，文件名（不含.json后缀）即接入类型
+func LoadDir(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		logger.LOG_WARN("加载接入参数schema异常，", err)
+		return
+	}
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			logger.LOG_WARN("读取接入参数schema异常，", f, err)
+			continue
+		}
+		accessType := strings.TrimSuffix(filepath.Base(f), ".json")
+		err = Register(accessType, raw)
+		if err != nil {
+			logger.LOG_WARN(err)
+			continue
+		}
+		logger.LOG_INFO("加载接入参数schema：", accessType)
+	}
+}
+
+//获取接入类型的schema，未注册返回nil
+func Get(accessType string) *Schema {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return registry[accessType]
+}
+
+//校验接入参数，未注册schema的接入类型不做校验
+func Validate(accessType, accessParam string) error {
+	s := Get(accessType)
+	if s == nil {
+		return nil
+	}
+	doc, err := parse(accessParam)
+	if err != nil {
+		return fmt.Errorf("接入参数不是合法的json：%v", err)
+	}
+	return s.Validate(doc)
+}
+
+//语义比较两个接入参数，忽略字段顺序和空白
+func Equal(a, b string) bool {
+	if a == b {
+		return true
+	}
+	da, err := parse(a)
+	if err != nil {
+		return false
+	}
+	db, err := parse(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(da, db)
+}
+
+func parse(param string) (doc interface{}, err error) {
+	param = strings.TrimSpace(param)
+	if param == "" {
+		return map[string]interface{}{}, nil
+	}
+	err = json.Unmarshal([]byte(param), &doc)
+	return doc, err
+}
@@ -0,0 +1,114 @@
+package schema
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["url", "mode"],
+	"additionalProperties": false,
+	"properties": {
+		"url": {"type": "string", "pattern": "^rtsp://", "minLength": 8},
+		"mode": {"type": "string", "enum": ["tcp", "udp"]},
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"channels": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"auth": {
+			"type": "object",
+			"required": ["user"],
+			"properties": {"user": {"type": "string"}, "level": {"enum": [1, 2]}}
+		}
+	}
+}`
+
+//出错字段路径，无错误返回nil
+func errorPaths(err error) []string {
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		return []string{err.Error()}
+	}
+	paths := make([]string, 0, len(errs))
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestValidate(t *testing.T) {
+	if err := Register("rtsp-test", []byte(testSchema)); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name  string
+		param string
+		paths []string
+	}{
+		{"合法", `{"url":"rtsp://a/b","mode":"tcp","port":554,"channels":["1"],"auth":{"user":"admin","level":2}}`, nil},
+		{"缺少必填", `{"url":"rtsp://a/b"}`, []string{"mode"}},
+		{"全部缺少", `{}`, []string{"mode", "url"}},
+		{"空参数按空对象", ``, []string{"mode", "url"}},
+		{"嵌套必填", `{"url":"rtsp://a/b","mode":"tcp","auth":{}}`, []string{"auth.user"}},
+		{"类型错误", `{"url":1,"mode":"tcp"}`, []string{"url"}},
+		{"整数类型", `{"url":"rtsp://a/b","mode":"tcp","port":554.5}`, []string{"port"}},
+		{"根类型错误", `[]`, []string{""}},
+		{"数组元素类型", `{"url":"rtsp://a/b","mode":"tcp","channels":[1]}`, []string{"channels[0]"}},
+		{"枚举", `{"url":"rtsp://a/b","mode":"http"}`, []string{"mode"}},
+		{"数字枚举", `{"url":"rtsp://a/b","mode":"tcp","auth":{"user":"u","level":3}}`, []string{"auth.level"}},
+		{"范围", `{"url":"rtsp://a/b","mode":"tcp","port":0}`, []string{"port"}},
+		{"格式", `{"url":"http://a/b","mode":"tcp"}`, []string{"url"}},
+		{"元素个数", `{"url":"rtsp://a/b","mode":"tcp","channels":["1","2","3"]}`, []string{"channels"}},
+		{"不允许的字段", `{"url":"rtsp://a/b","mode":"tcp","extra":1}`, []string{"extra"}},
+		{"多个错误", `{"url":"rtsp://a/b","mode":"http","port":"554"}`, []string{"mode", "port"}},
+	}
+	for _, c := range cases {
+		if got := errorPaths(Validate("rtsp-test", c.param)); !reflect.DeepEqual(got, c.paths) {
+			t.Errorf("%s：错误字段%v，期望%v", c.name, got, c.paths)
+		}
+	}
+	if err := Validate("rtsp-test", `{`); err == nil {
+		t.Error("非法json应校验失败")
+	}
+	if err := Validate("unregistered", `{`); err != nil {
+		t.Errorf("未注册schema的接入类型不应校验：%v", err)
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	if err := Register("bad-json", []byte(`{`)); err == nil {
+		t.Error("schema不是合法json时应返回错误")
+	}
+	if err := Register("bad-pattern", []byte(`{"properties":{"a":{"pattern":"("}}}`)); err == nil {
+		t.Error("pattern不合法时应返回错误")
+	}
+	if Get("bad-pattern") != nil {
+		t.Error("编译失败的schema不应注册")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{`{"url":"rtsp://a","mode":"tcp"}`, `{"mode":"tcp","url":"rtsp://a"}`, true},
+		{`{"a":{"x":1,"y":2}}`, "{\n  \"a\": {\"y\": 2, \"x\": 1}\n}", true},
+		{``, `{}`, true},
+		{`  `, ``, true},
+		{`{"a":[1,2]}`, `{"a":[2,1]}`, false},
+		{`{"a":1}`, `{"a":"1"}`, false},
+		{`{"a":1}`, `{"a":1,"b":null}`, false},
+		{`{`, `{}`, false},
+		{`{`, `{`, true},
+	}
+	for _, c := range cases {
+		if got := Equal(c.a, c.b); got != c.equal {
+			t.Errorf("Equal(%q, %q) = %v，期望%v", c.a, c.b, got, c.equal)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package sdk
+
+import (
+	"bytes"
+	"dyzs/galaxy/logger"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//特殊的Target
+const (
+	TARGET_GALAXY = "galaxy" //galaxy自身处理的指令
+	TARGET_MEDIA  = "media"  //媒体服务
+	TARGET_FROM   = "from"   //异步响应，TargetId为收到指令时的TargetFrom
+)
+
+//调用galaxy /cmd的客户端
+type Client struct {
+	address string
+	client  *http.Client
+}
+
+//按环境变量GALAXY_HOST/GALAXY_PORT创建客户端
+func NewClient() *Client {
+	return NewClientWithAddress(LoadEnv().GalaxyAddress())
+}
+
+//按指定地址创建客户端，address格式 ip:port
+func NewClientWithAddress(address string) *Client {
+	return &Client{
+		address: address,
+		client: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConns:        20,
+				MaxIdleConnsPerHost: 5,
+				IdleConnTimeout:     30 * time.Second,
+			},
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+//发送指令到target（资源编号/国标编码/通道编号，或TARGET_*），resPointer为空时忽略响应
+func (c *Client) Cmd(target, cmd string, param interface{}, resPointer interface{}) error {
+	return c.do(target, "", map[string]interface{}{
+		"Cmd":    cmd,
+		"Target": target,
+		"Param":  param,
+	}, resPointer)
+}
+
+//发送galaxy指令，响应的Result解析到resPointer
+func (c *Client) GalaxyCmd(cmd string, param interface{}, resPointer interface{}) error {
+	res := &CommandResponse{}
+	var result jsoniter.RawMessage
+	res.Result = &result
+	err := c.do(TARGET_GALAXY, "", map[string]interface{}{
+		"cmd":   cmd,
+		"param": param,
+	}, res)
+	if err != nil {
+		return err
+	}
+	if res.Code != http.StatusOK {
+		return errors.New("galaxy响应异常，code:" + strconv.Itoa(res.Code) + "，" + res.Message)
+	}
+	if resPointer != nil && len(result) > 0 {
+		return jsoniter.Unmarshal(result, resPointer)
+	}
+	return nil
+}
+
+//异步响应，from为收到指令时的TargetFrom
+func (c *Client) Reply(from string, body interface{}) error {
+	return c.do(TARGET_FROM, from, body, nil)
+}
+
+func (c *Client) do(target, targetId string, body interface{}, resPointer interface{}) error {
+	bodyBytes, err := jsoniter.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := "http://" + c.address + "/cmd"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Target", target)
+	if targetId != "" {
+		req.Header.Set("TargetId", targetId)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			logger.LOG_WARN("关闭res失败", err)
+		}
+	}()
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.New("galaxy响应异常，status:" + strconv.Itoa(res.StatusCode) + "，" + string(resBytes))
+	}
+	if resPointer != nil && len(resBytes) > 0 {
+		return jsoniter.Unmarshal(resBytes, resPointer)
+	}
+	return nil
+}
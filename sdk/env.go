@@ -0,0 +1,44 @@
+//任务组件SDK：实现Handler后通过NewServer(handler).Run()提供/mapi/*管理接口和/cmd指令入口，
+//通过NewClient()调用galaxy的/cmd
+package sdk
+
+import (
+	"os"
+	"strconv"
+)
+
+//galaxy启动任务容器时注入的环境变量
+type Env struct {
+	GalaxyIP   string //历史变量，实际为中心地址，访问galaxy请使用GalaxyHost
+	GalaxyHost string //galaxy地址
+	GalaxyPort string
+	ManagePort int
+	Host       string
+	LogLevel   string
+	CenterIP   string
+	CenterPort string
+}
+
+//读取环境变量
+func LoadEnv() *Env {
+	managePort, _ := strconv.Atoi(os.Getenv("MANAGE_PORT"))
+	return &Env{
+		GalaxyIP:   os.Getenv("GALAXY_IP"),
+		GalaxyHost: os.Getenv("GALAXY_HOST"),
+		GalaxyPort: os.Getenv("GALAXY_PORT"),
+		ManagePort: managePort,
+		Host:       os.Getenv("HOST"),
+		LogLevel:   os.Getenv("LOG_LEVEL"),
+		CenterIP:   os.Getenv("CENTER_IP"),
+		CenterPort: os.Getenv("CENTER_PORT"),
+	}
+}
+
+//galaxy地址，旧版本galaxy未注入GALAXY_HOST时使用HOST
+func (e *Env) GalaxyAddress() string {
+	host := e.GalaxyHost
+	if host == "" {
+		host = e.Host
+	}
+	return host + ":" + e.GalaxyPort
+}
@@ -0,0 +1,244 @@
+package sdk
+
+import (
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/mapi"
+	"dyzs/galaxy/model"
+	"errors"
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+//任务组件需实现的管理接口
+type Handler interface {
+	//初始化/更新任务配置
+	Init(task *model.Task) error
+	//下发资源（新增或变更）
+	Assign(resources []*model.Resource) error
+	//回收资源
+	Revoke(resourceIds []string) error
+}
+
+//可选：心跳自检，返回错误时galaxy将重启任务
+type Heartbeater interface {
+	Heart() error
+}
+
+//可选：心跳返回健康详情
+type HealthReporter interface {
+	Health() *mapi.HeartResponse
+}
+
+//可选：声明路由范围
+type RouteDeclarer interface {
+	Routes() (keys []string, ids []string)
+}
+
+//可选：处理galaxy转发的/cmd指令
+type CommandHandler interface {
+	HandleCmd(cmd *Command) (interface{}, error)
+}
+
+//指令
+type Command struct {
+	Cmd    string              `json:"Cmd"`
+	Target string              `json:"Target"`
+	Param  jsoniter.RawMessage `json:"Param"`
+
+	From string `json:"-"` //TargetFrom请求头，异步响应时原样作为TargetId回传
+}
+
+//指令响应
+type CommandResponse struct {
+	Code    int         `json:"Code"`
+	Message string      `json:"Message"`
+	Result  interface{} `json:"Result"`
+}
+
+var ErrUnknownCommand = errors.New("未找到指令匹配的处理器")
+
+//管理接口服务
+type Server struct {
+	handler     Handler
+	engine      *gin.Engine
+	assignChunk int
+	server      *http.Server
+}
+
+//创建管理接口服务
+func NewServer(handler Handler) *Server {
+	s := &Server{
+		handler:     handler,
+		assignChunk: mapi.DEFAULT_ASSIGN_CHUNK,
+	}
+	s.engine = gin.New()
+	s.engine.Use(gin.Recovery())
+	s.engine.POST(mapi.PATH_INIT, s.init)
+	s.engine.POST(mapi.PATH_HEART, s.heart)
+	s.engine.POST(mapi.PATH_ASSIGN_RESOURCE, s.assign)
+	s.engine.POST(mapi.PATH_REVOKE_RESOURCE, s.revoke)
+	s.engine.Any(mapi.PATH_CMD, s.cmd)
+	return s
+}
+
+//设置分批下发时每批资源数
+func (s *Server) SetAssignChunk(chunk int) {
+	if chunk > 0 {
+		s.assignChunk = chunk
+	}
+}
+
+//http处理器，可挂载到自有http服务
+func (s *Server) Handler() http.Handler {
+	return s.engine
+}
+
+//监听MANAGE_PORT
+func (s *Server) Run() error {
+	env := LoadEnv()
+	if env.ManagePort <= 0 {
+		return errors.New("环境变量MANAGE_PORT未设置")
+	}
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(env.ManagePort))
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+//在指定监听上提供服务
+func (s *Server) Serve(l net.Listener) error {
+	s.server = &http.Server{Handler: s.engine}
+	return s.server.Serve(l)
+}
+
+//关闭服务
+func (s *Server) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+func (s *Server) init(c *gin.Context) {
+	task := &model.Task{}
+	if !bind(c, task) {
+		return
+	}
+	err := s.handler.Init(task)
+	if err != nil {
+		logger.LOG_WARN("任务init异常，", err)
+		failure(c, err)
+		return
+	}
+	//旧版galaxy不携带版本请求头，按旧协议响应
+	if c.GetHeader(mapi.HEADER_API_VERSION) == "" {
+		success(c, "success")
+		return
+	}
+	res := &mapi.InitResponse{
+		ApiVersion:  mapi.VERSION,
+		Features:    []string{mapi.FEATURE_CHUNKED_ASSIGN},
+		AssignChunk: s.assignChunk,
+	}
+	if _, ok := s.handler.(HealthReporter); ok {
+		res.Features = append(res.Features, mapi.FEATURE_HEALTH_DETAIL)
+	}
+	if rd, ok := s.handler.(RouteDeclarer); ok {
+		res.Features = append(res.Features, mapi.FEATURE_ROUTE_DECLARE)
+		res.RouteKeys, res.RouteIds = rd.Routes()
+	}
+	success(c, res)
+}
+
+func (s *Server) heart(c *gin.Context) {
+	if hb, ok := s.handler.(Heartbeater); ok {
+		if err := hb.Heart(); err != nil {
+			logger.LOG_WARN("任务心跳自检异常，", err)
+			failure(c, err)
+			return
+		}
+	}
+	if hr, ok := s.handler.(HealthReporter); ok {
+		success(c, hr.Health())
+		return
+	}
+	success(c, "success")
+}
+
+func (s *Server) assign(c *gin.Context) {
+	resources := make([]*model.Resource, 0)
+	if !bind(c, &resources) {
+		return
+	}
+	err := s.handler.Assign(resources)
+	if err != nil {
+		logger.LOG_WARN("下发资源异常，", err)
+		failure(c, err)
+		return
+	}
+	success(c, "success")
+}
+
+func (s *Server) revoke(c *gin.Context) {
+	resourceIds := make([]string, 0)
+	if !bind(c, &resourceIds) {
+		return
+	}
+	err := s.handler.Revoke(resourceIds)
+	if err != nil {
+		logger.LOG_WARN("回收资源异常，", err)
+		failure(c, err)
+		return
+	}
+	success(c, "success")
+}
+
+func (s *Server) cmd(c *gin.Context) {
+	cmd := &Command{}
+	if !bind(c, cmd) {
+		return
+	}
+	cmd.From = c.GetHeader("TargetFrom")
+	ch, isHandler := s.handler.(CommandHandler)
+	if !isHandler {
+		c.JSON(http.StatusOK, &CommandResponse{Code: http.StatusNotFound, Message: ErrUnknownCommand.Error()})
+		return
+	}
+	result, err := ch.HandleCmd(cmd)
+	if err != nil {
+		c.JSON(http.StatusOK, &CommandResponse{Code: -1, Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, &CommandResponse{Code: http.StatusOK, Message: "success", Result: result})
+}
+
+func bind(c *gin.Context, v interface{}) bool {
+	body, err := c.GetRawData()
+	if err == nil {
+		err = jsoniter.Unmarshal(body, v)
+	}
+	if err != nil {
+		logger.LOG_WARN("参数解析异常:", err)
+		failure(c, err)
+		return false
+	}
+	return true
+}
+
+func success(c *gin.Context, msg interface{}) {
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"code": http.StatusOK,
+		"msg":  msg,
+	})
+}
+
+func failure(c *gin.Context, err error) {
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"code": http.StatusInternalServerError,
+		"msg":  err.Error(),
+	})
+}
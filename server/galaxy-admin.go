@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/subtle"
+	"dyzs/galaxy/catalog"
+	"dyzs/galaxy/dispatcher"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//配置中需要隐藏的字段，完整key，viper的key为小写
+var _SECRET_CONFIG_KEYS = map[string]bool{
+	"admin.token":               true,
+	"center.auth.provisioncode": true,
+	"center.mqtt.password":      true,
+}
+
+//管理接口 /api/v1，需通过adminAuth
+func (chs *ConfigHttpServer) initAdminApi(engine *gin.Engine) {
+	v1 := engine.Group("/api/v1", adminAuth)
+	//查询
+	v1.GET("/tasks", chs.apiTasks)
+	v1.GET("/tasks/:id", chs.apiTask)
+	v1.GET("/workers", chs.apiWorkers)
+	v1.GET("/routes", chs.routes)
+	v1.GET("/centers", chs.centers)
+	v1.GET("/resilience", chs.resilience)
+	v1.GET("/outbox", chs.outbox)
+	v1.GET("/channels", chs.apiChannels)
+	v1.GET("/catalog/tree", chs.catalogTree)
+	v1.GET("/catalog/status", chs.catalogStatus)
+	v1.GET("/catalog/status/history", chs.catalogStatusHistory)
+	v1.GET("/ws", chs.apiWs)
+	v1.GET("/config", chs.apiConfig)
+	//操作
+	v1.POST("/tasks/:id/restart", chs.apiTaskAction(chs.td.RestartTask))
+	v1.POST("/tasks/:id/reinit", chs.apiTaskAction(chs.td.ReinitTask))
+	v1.POST("/tasks/:id/resync", chs.apiTaskAction(chs.td.ResyncResources))
+	v1.POST("/heartbeat", chs.apiHeartbeat)
+}
+
+//管理接口认证：配置admin.token时请求头需带 Authorization: Bearer {token}；未配置时只允许本机访问
+func adminAuth(ctx *gin.Context) {
+	token := viper.GetString("admin.token")
+	if token == "" {
+		if !loopback(ctx.Request.RemoteAddr) {
+			apiError(ctx, http.StatusForbidden, errors.New("管理接口仅允许本机访问，远程访问需配置admin.token"))
+			ctx.Abort()
+		}
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(ctx.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+		apiError(ctx, http.StatusUnauthorized, errors.New("管理接口认证失败"))
+		ctx.Abort()
+	}
+}
+
+//请求是否来自本机，不信任X-Forwarded-For等请求头
+func loopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (chs *ConfigHttpServer) apiTasks(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, chs.td.Tasks())
+}
+
+func (chs *ConfigHttpServer) apiTask(ctx *gin.Context) {
+	task, ok := chs.td.Task(ctx.Param("id"))
+	if !ok {
+		apiError(ctx, http.StatusNotFound, dispatcher.ErrTaskNotFound)
+		return
+	}
+	ctx.JSON(http.StatusOK, task)
+}
+
+func (chs *ConfigHttpServer) apiWorkers(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, chs.td.Workers())
+}
+
+//查询通道，参数同QueryChannelList：fromId、civilCode、parentId、status、category、name、pageNo、pageSize、orderBy、desc
+func (chs *ConfigHttpServer) apiChannels(ctx *gin.Context) {
+	pageNo, _ := strconv.Atoi(ctx.Query("pageNo"))
+	pageSize, _ := strconv.Atoi(ctx.Query("pageSize"))
+	desc, _ := strconv.ParseBool(ctx.Query("desc"))
+	ctx.JSON(http.StatusOK, chs.channels.Query(&catalog.Query{
+		FromID:    ctx.Query("fromId"),
+		CivilCode: ctx.Query("civilCode"),
+		ParentID:  ctx.Query("parentId"),
+		Status:    ctx.Query("status"),
+		Category:  ctx.Query("category"),
+		Name:      ctx.Query("name"),
+		PageNo:    pageNo,
+		PageSize:  pageSize,
+		OrderBy:   ctx.Query("orderBy"),
+		Desc:      desc,
+	}))
+}
+
+func (chs *ConfigHttpServer) apiWs(ctx *gin.Context) {
+	if chs.previewWs == nil {
+		ctx.JSON(http.StatusOK, &WsStatus{})
+		return
+	}
+	ctx.JSON(http.StatusOK, chs.previewWs.Status())
+}
+
+//当前配置，密码、密钥等字段隐藏
+func (chs *ConfigHttpServer) apiConfig(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, maskConfig(viper.AllSettings()))
+}
+
+//任务操作
+func (chs *ConfigHttpServer) apiTaskAction(action func(taskId string) error) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := action(ctx.Param("id"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, dispatcher.ErrTaskNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, dispatcher.ErrWorkerNotFound), errors.Is(err, dispatcher.ErrTaskNotInited):
+				status = http.StatusConflict
+			}
+			apiError(ctx, status, err)
+			return
+		}
+		apiSuccess(ctx)
+	}
+}
+
+//立即发送心跳
+func (chs *ConfigHttpServer) apiHeartbeat(ctx *gin.Context) {
+	chs.td.TriggerHeart()
+	apiSuccess(ctx)
+}
+
+func apiSuccess(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"code":    http.StatusOK,
+		"message": "success",
+	})
+}
+
+func apiError(ctx *gin.Context, status int, err error) {
+	ctx.JSON(status, map[string]interface{}{
+		"code":    status,
+		"message": err.Error(),
+	})
+}
+
+func maskConfig(settings map[string]interface{}) map[string]interface{} {
+	return maskSettings("", settings)
+}
+
+//prefix为上级key，如center.auth.
+func maskSettings(prefix string, settings map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		key := prefix + strings.ToLower(k)
+		if _SECRET_CONFIG_KEYS[key] {
+			res[k] = "******"
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			res[k] = maskSettings(key+".", m)
+			continue
+		}
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"dyzs/galaxy/gbid"
+	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/outbox"
+	"encoding/json"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+const _PATH_CENTER_SUBMIT_CHANNEL = "/management/sensor/submitChannels"
+
+//上报队列中的通道数据类型，按设备合并新增/变更和删除
+const _OUTBOX_KIND_CHANNELS = "channels"
+
+const _DEFAULT_FULL_SYNC_INTERVAL = 24 * time.Hour
+
+//每个全量同步间隔内检查的次数，设备最迟在间隔的(1+1/_FULL_SYNC_CHECKS)倍时全量同步
+const _FULL_SYNC_CHECKS = 10
+
+//设备目录分页上报的间隔超过该时间时重新计数
+const _CATALOG_PAGE_TIMEOUT = time.Minute
+
+//待上报的设备通道，Channels为新增或变更，Removed为删除的通道编号
+type channelSubmission struct {
+	Gid      string                   `json:"gid"`
+	Channels []map[string]interface{} `json:"channels"`
+	Removed  []string                 `json:"removed,omitempty"`
+}
+
+//全量同步记录，重启后每个设备首次上报目录时全量同步
+type fullSyncs struct {
+	sync.Mutex
+	last map[string]time.Time
+}
+
+func (fs *fullSyncs) due(deviceId string, now time.Time) bool {
+	fs.Lock()
+	defer fs.Unlock()
+	last, ok := fs.last[deviceId]
+	return !ok || now.Sub(last) >= fullSyncInterval()
+}
+
+func (fs *fullSyncs) done(deviceId string, now time.Time) {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.last[deviceId] = now
+}
+
+//设备目录分页上报进度，按设备累计收到的通道，收齐SumNum个时为完整目录
+type catalogPages struct {
+	sync.Mutex
+	devices map[string]*catalogPage
+}
+
+type catalogPage struct {
+	sumNum   int
+	received map[string]bool
+	updated  time.Time
+}
+
+//记录设备本次上报的通道，收齐完整目录时返回所有页的通道编号并重新计数，否则返回nil
+func (cp *catalogPages) add(deviceId string, sumNum int, channels []*model.Channel, now time.Time) map[string]bool {
+	if sumNum <= 0 {
+		return nil
+	}
+	cp.Lock()
+	defer cp.Unlock()
+	page := cp.devices[deviceId]
+	if page == nil || page.sumNum != sumNum || now.Sub(page.updated) > _CATALOG_PAGE_TIMEOUT {
+		page = &catalogPage{sumNum: sumNum, received: make(map[string]bool)}
+		cp.devices[deviceId] = page
+	}
+	for _, c := range channels {
+		page.received[c.DeviceID] = true
+	}
+	page.updated = now
+	if len(page.received) < sumNum {
+		return nil
+	}
+	delete(cp.devices, deviceId)
+	return page.received
+}
+
+//全量同步间隔，catalog.fullSyncInterval
+func fullSyncInterval() time.Duration {
+	if d := viper.GetDuration("catalog.fullSyncInterval"); d > 0 {
+		return d
+	}
+	return _DEFAULT_FULL_SYNC_INTERVAL
+}
+
+//定期全量同步所有设备的目录，各设备按自己上次全量同步的时间判断是否到期
+func (chs *ConfigHttpServer) loopFullSync() {
+	check := fullSyncInterval() / _FULL_SYNC_CHECKS
+	if check < time.Second {
+		check = time.Second
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		for _, deviceId := range chs.channels.Devices() {
+			if chs.fullSyncs.due(deviceId, now) {
+				chs.fullSync(deviceId, now)
+			}
+		}
+	}
+}
+
+//保存设备上报的目录，只将差异写入上报队列；到达全量同步时间时上报设备全部通道；
+//received为收齐的完整目录通道编号，未收齐时为nil
+func (chs *ConfigHttpServer) syncCatalog(deviceId string, channels []*model.Channel, received map[string]bool) {
+	diff := chs.channels.Sync(deviceId, channels, received)
+	submission := &channelSubmission{Gid: deviceId}
+	for _, c := range diff.Removed {
+		submission.Removed = append(submission.Removed, c.DeviceID)
+	}
+	now := time.Now()
+	if chs.fullSyncs.due(deviceId, now) {
+		submission.Channels = centerChannels(chs.channels.ByDevice(deviceId))
+		chs.fullSyncs.done(deviceId, now)
+	} else {
+		submission.Channels = centerChannels(append(diff.Added, diff.Changed...))
+	}
+	logger.LOG_INFO("设备目录：", deviceId, "，新增", len(diff.Added), "，变更", len(diff.Changed), "，删除", len(diff.Removed), "，上报", len(submission.Channels))
+	enqueueChannels(submission)
+}
+
+func (chs *ConfigHttpServer) fullSync(deviceId string, now time.Time) {
+	enqueueChannels(&channelSubmission{
+		Gid:      deviceId,
+		Channels: centerChannels(chs.channels.ByDevice(deviceId)),
+	})
+	chs.fullSyncs.done(deviceId, now)
+}
+
+//写入上报队列，由队列重试直到中心确认
+func enqueueChannels(submission *channelSubmission) {
+	if len(submission.Channels) == 0 && len(submission.Removed) == 0 {
+		return
+	}
+	body, err := json.Marshal(submission)
+	if err != nil {
+		logger.LOG_WARN("通道信息序列化异常：", err)
+		return
+	}
+	outbox.Default().Enqueue(_OUTBOX_KIND_CHANNELS+":"+submission.Gid, _OUTBOX_KIND_CHANNELS, body)
+}
+
+//上报中心的通道字段，业务分组、虚拟组织为目录节点，不作为通道上报
+func centerChannels(channels []*model.Channel) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(channels))
+	for _, c := range channels {
+		if c.Category == gbid.CATEGORY_GROUP {
+			continue
+		}
+		res = append(res, map[string]interface{}{
+			"channelNo": c.DeviceID,
+			"id":        "",
+			"name":      c.Name,
+			"parentId":  "",
+			"ptzType":   c.PTZType,
+			"typeCode":  c.TypeCode,
+			"status":    c.Status,
+		})
+	}
+	return res
+}
+
+//上报设备通道，新增/变更在channels，删除的通道编号在removed，一次提交到submitChannels
+func (chs *ConfigHttpServer) sendChannels(payload []byte) error {
+	submission := &channelSubmission{}
+	err := json.Unmarshal(payload, submission)
+	if err != nil {
+		logger.LOG_WARN("待上报通道解析异常，丢弃：", err)
+		return nil
+	}
+	if submission.Channels == nil {
+		submission.Channels = make([]map[string]interface{}, 0)
+	}
+	body, _ := json.Marshal(submission)
+	return chs.post(_PATH_CENTER_SUBMIT_CHANNEL, body)
+}
+
+//合并同一设备未上报的通道：按通道编号去重，新数据优先；新增与删除互相抵消
+func mergeChannels(old, payload []byte) ([]byte, error) {
+	o, n := &channelSubmission{}, &channelSubmission{}
+	err := json.Unmarshal(old, o)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(payload, n)
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool)
+	for _, id := range o.Removed {
+		removed[id] = true
+	}
+	for _, id := range n.Removed {
+		removed[id] = true
+	}
+	channels := make(map[string]map[string]interface{})
+	order := make([]string, 0, len(o.Channels)+len(n.Channels))
+	for _, ch := range append(o.Channels, n.Channels...) {
+		id, _ := ch["channelNo"].(string)
+		if _, ok := channels[id]; !ok {
+			order = append(order, id)
+		}
+		channels[id] = ch
+	}
+	//新数据中再次上报的通道不再删除，新数据中删除的通道不再上报
+	for _, ch := range n.Channels {
+		id, _ := ch["channelNo"].(string)
+		delete(removed, id)
+	}
+	for _, id := range n.Removed {
+		delete(channels, id)
+	}
+	n.Channels = make([]map[string]interface{}, 0, len(channels))
+	for _, id := range order {
+		if ch, ok := channels[id]; ok {
+			n.Channels = append(n.Channels, ch)
+		}
+	}
+	removedIds := make([]string, 0, len(removed))
+	for _, id := range append(o.Removed, n.Removed...) {
+		if removed[id] {
+			removedIds = append(removedIds, id)
+			delete(removed, id)
+		}
+	}
+	n.Removed = removedIds
+	return json.Marshal(n)
+}
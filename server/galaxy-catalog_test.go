@@ -0,0 +1,120 @@
+package server
+
+import (
+	"dyzs/galaxy/model"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//channels为 通道编号:名称
+func testSubmission(channels []string, removed ...string) []byte {
+	s := &channelSubmission{Gid: "P", Channels: make([]map[string]interface{}, 0), Removed: removed}
+	for _, c := range channels {
+		s.Channels = append(s.Channels, map[string]interface{}{"channelNo": c[:2], "name": c[3:]})
+	}
+	body, _ := json.Marshal(s)
+	return body
+}
+
+func TestMergeChannels(t *testing.T) {
+	cases := []struct {
+		name     string
+		old      []byte
+		payload  []byte
+		channels []string
+		removed  []string
+	}{
+		{
+			name:     "按通道编号去重，新数据优先，保持首次出现的顺序",
+			old:      testSubmission([]string{"c1:a", "c2:a"}),
+			payload:  testSubmission([]string{"c3:b", "c1:b"}),
+			channels: []string{"c1:b", "c2:a", "c3:b"},
+		},
+		{
+			name:     "先删除后重新上报，不再删除",
+			old:      testSubmission(nil, "c1", "c2"),
+			payload:  testSubmission([]string{"c1:b"}),
+			channels: []string{"c1:b"},
+			removed:  []string{"c2"},
+		},
+		{
+			name:     "先上报后删除，不再上报",
+			old:      testSubmission([]string{"c1:a", "c2:a"}),
+			payload:  testSubmission(nil, "c1"),
+			channels: []string{"c2:a"},
+			removed:  []string{"c1"},
+		},
+		{
+			name:     "删除、重新上报、再删除",
+			old:      mustMerge(testSubmission(nil, "c1"), testSubmission([]string{"c1:b"})),
+			payload:  testSubmission(nil, "c1"),
+			channels: []string{},
+			removed:  []string{"c1"},
+		},
+		{
+			name:     "重复删除只保留一次",
+			old:      testSubmission(nil, "c1", "c2"),
+			payload:  testSubmission(nil, "c2", "c3"),
+			channels: []string{},
+			removed:  []string{"c1", "c2", "c3"},
+		},
+	}
+	for _, c := range cases {
+		merged, err := mergeChannels(c.old, c.payload)
+		if err != nil {
+			t.Fatalf("%s：%v", c.name, err)
+		}
+		s := &channelSubmission{}
+		if err := json.Unmarshal(merged, s); err != nil {
+			t.Fatalf("%s：%v", c.name, err)
+		}
+		channels := make([]string, 0, len(s.Channels))
+		for _, ch := range s.Channels {
+			channels = append(channels, ch["channelNo"].(string)+":"+ch["name"].(string))
+		}
+		if !reflect.DeepEqual(channels, c.channels) {
+			t.Errorf("%s：通道%v，期望%v", c.name, channels, c.channels)
+		}
+		if len(s.Removed)+len(c.removed) > 0 && !reflect.DeepEqual(s.Removed, c.removed) {
+			t.Errorf("%s：删除%v，期望%v", c.name, s.Removed, c.removed)
+		}
+		if s.Gid != "P" {
+			t.Errorf("%s：设备编号丢失：%s", c.name, s.Gid)
+		}
+	}
+	if _, err := mergeChannels([]byte("{"), testSubmission(nil)); err == nil {
+		t.Error("旧数据格式错误时应返回错误")
+	}
+}
+
+func mustMerge(old, payload []byte) []byte {
+	merged, err := mergeChannels(old, payload)
+	if err != nil {
+		panic(err)
+	}
+	return merged
+}
+
+func TestCatalogPages(t *testing.T) {
+	cp := &catalogPages{devices: make(map[string]*catalogPage)}
+	now := time.Now()
+	page1 := []*model.Channel{{DeviceID: "c1"}, {DeviceID: "c2"}}
+	page2 := []*model.Channel{{DeviceID: "c3"}}
+	if received := cp.add("P", 3, page1, now); received != nil {
+		t.Fatalf("未收齐时返回了%v", received)
+	}
+	received := cp.add("P", 3, page2, now.Add(time.Second))
+	if !reflect.DeepEqual(received, map[string]bool{"c1": true, "c2": true, "c3": true}) {
+		t.Fatalf("收齐后返回%v", received)
+	}
+	//超时后重新计数
+	cp.add("P", 3, page1, now)
+	if received := cp.add("P", 3, page2, now.Add(_CATALOG_PAGE_TIMEOUT+time.Second)); received != nil {
+		t.Fatalf("超时后未重新计数：%v", received)
+	}
+	if received := cp.add("P", 0, page1, now); received != nil {
+		t.Fatalf("未知总数时返回了%v", received)
+	}
+}
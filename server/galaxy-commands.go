@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownCommand = errors.New("未知指令")
+
+//galaxy指令：Param返回参数结构体指针，无参数时为nil；Handle的返回值作为GalaxyResponse.Result
+type Command struct {
+	Name        string
+	Description string
+	Param       func() interface{}
+	Handle      func(param interface{}) (interface{}, error)
+	//参数不是json对象（如空串、数组）时使用参数零值，兼容旧调用方
+	LooseParam bool
+}
+
+//指令说明，供ListCommands查询
+type CommandInfo struct {
+	Name        string   `json:"Name"`
+	Description string   `json:"Description"`
+	Params      []string `json:"Params"` //参数字段名
+}
+
+//指令注册表
+type CommandRegistry struct {
+	sync.RWMutex
+	commands map[string]*Command
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{commands: make(map[string]*Command)}
+}
+
+//注册指令，同名指令覆盖
+func (cr *CommandRegistry) Register(cmd *Command) {
+	cr.Lock()
+	defer cr.Unlock()
+	cr.commands[cmd.Name] = cmd
+}
+
+func (cr *CommandRegistry) Get(name string) (*Command, bool) {
+	cr.RLock()
+	defer cr.RUnlock()
+	cmd, ok := cr.commands[name]
+	return cmd, ok
+}
+
+//执行指令，参数为空时使用参数零值
+func (cr *CommandRegistry) Execute(name string, raw jsoniter.RawMessage) (interface{}, error) {
+	cmd, ok := cr.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w：%s", ErrUnknownCommand, name)
+	}
+	var param interface{}
+	if cmd.Param != nil {
+		param = cmd.Param()
+		if len(raw) > 0 && string(raw) != "null" && (!cmd.LooseParam || isObject(raw)) {
+			err := jsoniter.Unmarshal(raw, param)
+			if err != nil {
+				return nil, errors.New("参数解析异常：" + err.Error())
+			}
+		}
+	}
+	return cmd.Handle(param)
+}
+
+func isObject(raw jsoniter.RawMessage) bool {
+	trimmed := strings.TrimSpace(string(raw))
+	return strings.HasPrefix(trimmed, "{")
+}
+
+//所有指令，按名称排序
+func (cr *CommandRegistry) List() []*CommandInfo {
+	cr.RLock()
+	defer cr.RUnlock()
+	res := make([]*CommandInfo, 0, len(cr.commands))
+	for _, cmd := range cr.commands {
+		info := &CommandInfo{Name: cmd.Name, Description: cmd.Description, Params: []string{}}
+		if cmd.Param != nil {
+			info.Params = paramNames(reflect.TypeOf(cmd.Param()))
+		}
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+//结构体的json字段名
+func paramNames(t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	names := make([]string, 0)
+	if t.Kind() != reflect.Struct {
+		return names
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
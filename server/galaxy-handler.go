@@ -2,21 +2,18 @@ package server
 
 import (
 	"bytes"
+	"dyzs/galaxy/catalog"
+	"dyzs/galaxy/gbid"
 	"dyzs/galaxy/logger"
-	"dyzs/galaxy/util"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/proxy"
 	"errors"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	jsoniter "github.com/json-iterator/go"
-	"github.com/spf13/viper"
-	"io/ioutil"
 	"net/http"
-	"strings"
 	"time"
 )
 
-const _URL_CENTER_SUBMIT_CHANNEL = "http://{CENTER_IP}:{CENTER_PORT}/management/sensor/submitChannels"
-
 func (chs *ConfigHttpServer) galaxyHandle(c *gin.Context) {
 	buff := bytes.NewBuffer(make([]byte, 0, c.Request.ContentLength))
 	_, err := buff.ReadFrom(c.Request.Body)
@@ -39,161 +36,139 @@ func (chs *ConfigHttpServer) galaxyHandle(c *gin.Context) {
 		return
 	}
 
-	switch cmd.Cmd {
-	case "ResponseCatalog":
-		chs.submitChannel(c, cmd.Param)
-	case "QueryChannelList":
-		chs.getAllChannels(c, cmd.Param)
-	case "QueryDeviceId":
-		chs.getResourceByChannel(c, cmd.Param)
-	default:
-		logger.LOG_WARN("未找到指令匹配的处理器：", cmd.Cmd)
-	}
-}
-
-/**
-提交设备通道
-*/
-func (chs *ConfigHttpServer) submitChannel(c *gin.Context, param jsoniter.RawMessage) {
-	catalogListParam := &CatalogListParam{}
-	fmt.Println(string(param))
-	err := jsoniter.Unmarshal(param, catalogListParam)
+	result, err := chs.commands.Execute(cmd.Cmd, cmd.Param)
 	if err != nil {
-		logger.LOG_WARN("参数解析异常:", err)
-		return
-	}
-	if len(catalogListParam.CatalogList) > 0 {
-		channelsReq := make(map[string][]map[string]interface{})
-		for _, c := range catalogListParam.CatalogList {
-			chs.channelMap[c.DeviceID] = c
-			if channelsReq[c.FromID] == nil {
-				channelsReq[c.FromID] = make([]map[string]interface{}, 0)
-			}
-			channelsReq[c.FromID] = append(channelsReq[c.FromID], map[string]interface{}{
-				"channelNo": c.DeviceID,
-				"id":        "",
-				"name":      c.Name,
-				"parentId":  "",
-				"ptzType":   c.PTZType,
-			})
-		}
-		//上报中心
-		for deviceId, c := range channelsReq {
-			err = util.Retry(func() error {
-				return chs.request(strings.ReplaceAll(strings.ReplaceAll(_URL_CENTER_SUBMIT_CHANNEL, "{CENTER_IP}", viper.GetString("center.host")), "{CENTER_PORT}", viper.GetString("center.managePort")), http.MethodPost, "application/json", map[string]interface{}{
-					"channels": c,
-					"gid":      deviceId,
-				}, nil)
-			}, 3, 1*time.Second)
-			if err != nil {
-				logger.LOG_WARN("上报通道信息异常：", err)
-				continue
-			}
+		logger.LOG_WARN("指令处理失败：", cmd.Cmd, "，", err)
+		code := -1
+		if errors.Is(err, ErrUnknownCommand) {
+			code = http.StatusNotFound
 		}
+		c.JSON(http.StatusOK, &GalaxyResponse{
+			Code:    code,
+			Message: err.Error(),
+		})
+		return
 	}
 	c.JSON(http.StatusOK, &GalaxyResponse{
 		Code:    http.StatusOK,
 		Message: "success",
+		Result:  result,
 	})
 }
 
-/**
-查询所有设备通道
-*/
-func (chs *ConfigHttpServer) getAllChannels(c *gin.Context, param jsoniter.RawMessage) {
-	channels := make([]*Channel, 0)
-	for _, c := range chs.channelMap {
-		channels = append(channels, c)
-	}
-	c.JSON(http.StatusOK, &GalaxyResponse{
-		Code:    http.StatusOK,
-		Message: "success",
-		Result: map[string]interface{}{
-			"ChannelList": channels,
+//注册galaxy指令
+func (chs *ConfigHttpServer) initCommands() {
+	chs.commands = NewCommandRegistry()
+	chs.commands.Register(&Command{
+		Name:        "ResponseCatalog",
+		Description: "提交设备目录",
+		Param:       func() interface{} { return &CatalogListParam{} },
+		Handle:      chs.submitChannel,
+	})
+	chs.commands.Register(&Command{
+		Name:        "QueryChannelList",
+		Description: "查询设备通道，支持过滤、分页和排序",
+		Param:       func() interface{} { return &catalog.Query{} },
+		Handle:      chs.getAllChannels,
+		LooseParam:  true,
+	})
+	chs.commands.Register(&Command{
+		Name:        "QueryDeviceId",
+		Description: "查询通道所属设备",
+		Param:       func() interface{} { return &DeviceIdParam{} },
+		Handle:      chs.getResourceByChannel,
+	})
+	chs.commands.Register(&Command{
+		Name:        "QueryCatalogTree",
+		Description: "查询目录树",
+		Param:       func() interface{} { return &catalog.TreeQuery{} },
+		Handle:      chs.getCatalogTree,
+	})
+	chs.commands.Register(&Command{
+		Name:        "QueryChannelStatus",
+		Description: "查询设备通道在线统计",
+		Param:       func() interface{} { return &ChannelStatusParam{} },
+		Handle:      chs.getChannelStatus,
+	})
+	chs.commands.Register(&Command{
+		Name:        "ListCommands",
+		Description: "查询支持的指令",
+		Handle: func(param interface{}) (interface{}, error) {
+			return map[string]interface{}{
+				"Commands": chs.commands.List(),
+			}, nil
 		},
 	})
 }
 
 /**
-通过id查询设备通道
+提交设备通道
 */
-func (chs *ConfigHttpServer) getResourceByChannel(c *gin.Context, param jsoniter.RawMessage) {
-	p := make(map[string]string)
-	err := jsoniter.Unmarshal(param, &p)
-	if err != nil {
-		logger.LOG_WARN("参数解析异常:", err)
-		goto ERR
-	}
-	if p["Channel"] == "" {
-		logger.LOG_WARN("参数解析异常,Channel为空")
-		goto ERR
+func (chs *ConfigHttpServer) submitChannel(param interface{}) (interface{}, error) {
+	catalogListParam := param.(*CatalogListParam)
+	if len(catalogListParam.CatalogList) > 0 {
+		byDevice := make(map[string][]*model.Channel)
+		for _, c := range catalogListParam.CatalogList {
+			classifyChannel(c)
+			byDevice[c.FromID] = append(byDevice[c.FromID], c)
+		}
+		now := time.Now()
+		for deviceId, channels := range byDevice {
+			//SumNum为每个设备的目录总数，分页上报时按设备累计，收齐后视为完整目录
+			received := chs.catalogPages.add(deviceId, catalogListParam.SumNum, channels, now)
+			chs.syncCatalog(deviceId, channels, received)
+		}
 	}
-	if ch, ok := chs.channelMap[p["Channel"]]; ok {
-		c.JSON(http.StatusOK, &GalaxyResponse{
-			Code:    http.StatusOK,
-			Message: "success",
-			Result: map[string]string{
-				"DeviceId": ch.FromID,
-			},
-		})
+	return nil, nil
+}
+
+//post到当前中心
+func (chs *ConfigHttpServer) post(path string, body []byte) error {
+	return proxy.Submit(path, body)
+}
+
+//按国标编码对通道分类
+func classifyChannel(c *model.Channel) {
+	id, err := gbid.Parse(c.DeviceID)
+	if err != nil {
+		logger.LOG_WARN("通道国标编码不合法：", c.DeviceID, "，", err)
+		c.TypeCode = 0
+		c.Category = gbid.CATEGORY_UNKNOWN
 		return
 	}
+	c.TypeCode = id.TypeCode
+	c.Category = id.Category()
+}
 
-ERR:
-	c.JSON(http.StatusOK, &GalaxyResponse{
-		Code:    -1,
-		Message: "未找到channelNo对应的设备",
-	})
+/**
+查询设备通道，支持按设备、行政区划、父节点、状态等过滤及分页排序，无参数时返回全部
+*/
+func (chs *ConfigHttpServer) getAllChannels(param interface{}) (interface{}, error) {
+	return chs.channels.Query(param.(*catalog.Query)), nil
 }
 
-//http请求
-func (chs *ConfigHttpServer) request(url, method, contentType string, body interface{}, resPointer interface{}) error {
-	var bodyBytes []byte
-	var resBytes []byte
-	if body != nil {
-		bodyBytes, _ = jsoniter.Marshal(body)
-	}
-	logger.LOG_INFO("http-request:", url)
-	if logger.IsDebug() {
-		params, err := jsoniter.Marshal(body)
-		if err != nil {
-			logger.LOG_WARN("http-request-params-error:", err)
-		} else {
-			logger.LOG_INFO("http-request-params:", string(params))
-		}
-	}
-	err := util.Retry(func() error {
-		req, err := http.NewRequest(method, url, bytes.NewReader(bodyBytes))
-		if err != nil {
-			return err
-		}
-		req.Header.Set("Content-Type", contentType)
-		res, err := chs.client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			err := res.Body.Close()
-			if err != nil {
-				logger.LOG_WARN("关闭res失败", err)
-			}
-		}()
-		resBytes, err = ioutil.ReadAll(res.Body)
-		if err != nil {
-			return err
-		}
-		_ = res.Body.Close()
-		if res.StatusCode != http.StatusOK {
-			return errors.New(string(resBytes))
-		}
-		return nil
-	}, 3, 3*time.Second)
-	if err != nil {
-		return err
+/**
+查询目录树，可按设备过滤、指定根节点和层数
+*/
+func (chs *ConfigHttpServer) getCatalogTree(param interface{}) (interface{}, error) {
+	return map[string]interface{}{
+		"Tree": chs.channels.Tree(param.(*catalog.TreeQuery)),
+	}, nil
+}
+
+/**
+通过id查询设备通道
+*/
+func (chs *ConfigHttpServer) getResourceByChannel(param interface{}) (interface{}, error) {
+	p := param.(*DeviceIdParam)
+	if p.Channel == "" {
+		return nil, errors.New("参数解析异常,Channel为空")
 	}
-	logger.LOG_INFO("http-response:", string(resBytes))
-	if resPointer != nil {
-		return jsoniter.Unmarshal(resBytes, resPointer)
+	ch, ok := chs.channels.Get(p.Channel)
+	if !ok {
+		return nil, errors.New("未找到channelNo对应的设备")
 	}
-	return nil
+	return map[string]string{
+		"DeviceId": ch.FromID,
+	}, nil
 }
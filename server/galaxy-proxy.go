@@ -1,7 +1,6 @@
 package server
 
 import (
-	"dyzs/galaxy/dispatcher"
 	"dyzs/galaxy/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
@@ -34,7 +33,7 @@ func (chs *ConfigHttpServer) proxy(ctx *gin.Context) {
 		//delete(chs.syncSessionMap, ctx.GetHeader("TargetId"))
 	} else {
 		//设备id：寻找组件
-		address = dispatcher.GetTaskAddressByResourceId(target)
+		address = chs.td.GetTaskAddress(target)
 	}
 	if address == "" {
 		logger.LOG_WARN("未找到目标进程，Target:", ctx.GetHeader("Target"), ",调用来源：", ctx.Request.RemoteAddr)
@@ -68,14 +67,14 @@ func (chs *ConfigHttpServer) proxy(ctx *gin.Context) {
 	}
 }
 
-func getAddress(target string) string {
+func (chs *ConfigHttpServer) getAddress(target string) string {
 	if target == "" {
 		return ""
 	}
 	if strings.ToLower(target) == "media" {
 		return viper.GetString("mediaAddress")
 	}
-	return dispatcher.GetTaskAddressByResourceId(target)
+	return chs.td.GetTaskAddress(target)
 }
 
 func (chs *ConfigHttpServer) forward(url string, ctx *gin.Context) {
@@ -1,23 +1,33 @@
 package server
 
 import (
+	"dyzs/galaxy/catalog"
+	"dyzs/galaxy/dispatcher"
 	"dyzs/galaxy/logger"
+	"dyzs/galaxy/outbox"
+	"dyzs/galaxy/proxy"
+	"dyzs/galaxy/resilience"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-func InitCofnigHttpServer() {
-	chs := &ConfigHttpServer{}
+func InitCofnigHttpServer(td *dispatcher.TaskDispatcher) {
+	chs := &ConfigHttpServer{td: td}
 	chs.Init()
 }
 
 type ConfigHttpServer struct {
 	server *http.Server
 	client *http.Client
+	td     *dispatcher.TaskDispatcher
 
-	channelMap map[string]*Channel
+	channels     *catalog.Registry
+	commands     *CommandRegistry
+	fullSyncs    *fullSyncs
+	catalogPages *catalogPages
 
 	previewWs *PreviewWebsocket
 
@@ -31,17 +41,33 @@ func (chs *ConfigHttpServer) Init() {
 			MaxIdleConnsPerHost: 5,
 			MaxConnsPerHost:     5,
 			IdleConnTimeout:     30 * time.Second,
+			//仅作用于https的中心地址
+			TLSClientConfig: proxy.TLSConfig(),
 		},
 		Timeout: 30 * time.Second,
 	}
-	chs.channelMap = make(map[string]*Channel)
+	chs.channels = catalog.NewRegistry(catalog.NewStore())
 	chs.syncSessionMap = make(map[string]string)
+	chs.channels.Start()
+	chs.initCommands()
+	chs.fullSyncs = &fullSyncs{last: make(map[string]time.Time)}
+	chs.catalogPages = &catalogPages{devices: make(map[string]*catalogPage)}
+	go chs.loopFullSync()
+
+	//通道上报队列
+	outbox.Default().RegisterSender(_OUTBOX_KIND_CHANNELS, chs.sendChannels)
+	outbox.Default().RegisterMerger(_OUTBOX_KIND_CHANNELS, mergeChannels)
+	chs.channels.OnStatusChange(chs.reportStatusChanges)
+	chs.channels.OnExpire(reportExpired)
+	outbox.Default().Start()
 
 	engin := gin.Default()
 	//变更日志级别
 	engin.Handle(http.MethodGet, "/debug", chs.debug)
 	//处理命令
 	engin.Any("/cmd", chs.cmd)
+	//管理接口
+	chs.initAdminApi(engin)
 
 	chs.server = &http.Server{
 		Handler: engin,
@@ -67,6 +93,47 @@ func (chs *ConfigHttpServer) debug(ctx *gin.Context) {
 	}
 }
 
+//查看路由表，key不为空时查询单个编号的路由
+func (chs *ConfigHttpServer) routes(ctx *gin.Context) {
+	key := ctx.Query("key")
+	if key == "" {
+		ctx.JSON(http.StatusOK, chs.td.Routes().Snapshot())
+		return
+	}
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"key":     key,
+		"taskIds": chs.td.Routes().Lookup(key),
+		"address": chs.td.GetTaskAddress(key),
+	})
+}
+
+func (chs *ConfigHttpServer) centers(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, proxy.Centers().Snapshot())
+}
+
+func (chs *ConfigHttpServer) resilience(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"heart":    chs.td.HeartStatus(),
+		"breakers": resilience.Breakers(),
+		"backoffs": resilience.KeyedPending(),
+		"outbox":   outbox.Default().Depth(),
+	})
+}
+
+func (chs *ConfigHttpServer) outbox(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, outbox.Default().Stats())
+}
+
+//查看目录树，参数fromId、root、depth
+func (chs *ConfigHttpServer) catalogTree(ctx *gin.Context) {
+	depth, _ := strconv.Atoi(ctx.Query("depth"))
+	ctx.JSON(http.StatusOK, chs.channels.Tree(&catalog.TreeQuery{
+		FromID: ctx.Query("fromId"),
+		Root:   ctx.Query("root"),
+		Depth:  depth,
+	}))
+}
+
 func (chs *ConfigHttpServer) cmd(ctx *gin.Context) {
 	target := ctx.GetHeader("Target")
 	if target == "galaxy" {
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"dyzs/galaxy/catalog"
+	"dyzs/galaxy/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+//通道状态变化按设备写入通道上报队列，通道信息带status经submitChannels上报，
+//与同一设备未上报的通道按通道编号合并
+func (chs *ConfigHttpServer) reportStatusChanges(changes []*catalog.StatusChange) {
+	byDevice := make(map[string][]*model.Channel)
+	for _, change := range changes {
+		c, ok := chs.channels.Get(change.DeviceID)
+		if !ok {
+			continue
+		}
+		byDevice[c.FromID] = append(byDevice[c.FromID], c)
+	}
+	for deviceId, channels := range byDevice {
+		enqueueChannels(&channelSubmission{Gid: deviceId, Channels: centerChannels(channels)})
+	}
+}
+
+//过期删除的通道按设备写入通道上报队列，通知中心删除
+func reportExpired(channels []*model.Channel) {
+	byDevice := make(map[string][]string)
+	for _, c := range channels {
+		byDevice[c.FromID] = append(byDevice[c.FromID], c.DeviceID)
+	}
+	for deviceId, ids := range byDevice {
+		enqueueChannels(&channelSubmission{Gid: deviceId, Removed: ids})
+	}
+}
+
+/**
+查询设备通道在线统计，FromId为空时返回所有设备
+*/
+func (chs *ConfigHttpServer) getChannelStatus(param interface{}) (interface{}, error) {
+	return map[string]interface{}{
+		"StatusList": chs.channels.StatusCounts(param.(*ChannelStatusParam).FromID),
+	}, nil
+}
+
+//查看设备在线统计，参数fromId
+func (chs *ConfigHttpServer) catalogStatus(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, chs.channels.StatusCounts(ctx.Query("fromId")))
+}
+
+//查看通道状态历史，参数channel
+func (chs *ConfigHttpServer) catalogStatusHistory(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, chs.channels.History(ctx.Query("channel")))
+}
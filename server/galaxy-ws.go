@@ -5,6 +5,8 @@ import (
 	"context"
 	"dyzs/galaxy/constants"
 	"dyzs/galaxy/logger"
+	"dyzs/galaxy/model"
+	"dyzs/galaxy/proxy"
 	"dyzs/galaxy/redis"
 	"encoding/json"
 	"errors"
@@ -25,6 +27,9 @@ const _WS_NAMESPACE = "video_preview"
 const _WS_SEND_MULTI = "sensorMultiple"
 const _WS_CONTENT_TYPE_JSON = "application/json"
 
+//中心通知任务变更，Param可携带完整任务列表
+const _WS_CMD_TASKS_CHANGED = "TasksChanged"
+
 type WsReceiveMessage struct {
 	RequestId   string          `json:"requestId"`
 	DayuId      string          `json:"dayuId"`
@@ -52,6 +57,10 @@ type VideoCmd struct {
 	Param  jsoniter.RawMessage `json:"Param"`
 }
 
+type TasksChangedParam struct {
+	Tasks []*model.Task `json:"tasks"`
+}
+
 type PreviewWebsocket struct {
 	e      *ConfigHttpServer
 	ws     *websocket.Conn
@@ -61,6 +70,24 @@ type PreviewWebsocket struct {
 	ctx         context.Context
 
 	msgC chan *WsReceiveMessage
+
+	//连接状态，由wsLock保护
+	address     string
+	connectedAt time.Time
+	lastMessage time.Time
+	received    int64
+	lastError   string
+}
+
+//websocket连接状态
+type WsStatus struct {
+	Connected   bool      `json:"connected"`
+	Address     string    `json:"address"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	LastMessage time.Time `json:"lastMessage"`
+	Received    int64     `json:"received"` //本次连接收到的消息数
+	LastError   string    `json:"lastError,omitempty"`
+	QueueLength int       `json:"queueLength"` //待处理消息数
 }
 
 func (e *ConfigHttpServer) initWs() {
@@ -96,31 +123,56 @@ LOOP:
 		if ws != nil {
 			continue
 		}
-		centerAddr := viper.GetString("center.host")
-		wsPort := viper.GetString("center.managePort")
+		ep, err := proxy.Centers().Current()
+		if err != nil {
+			logger.LOG_WARN(err)
+			continue
+		}
 		wsPath := _WS_PATH
 
-		logger.LOG_INFO("wsPort:", wsPort)
+		logger.LOG_INFO("wsAddress:", ep.Address())
 		logger.LOG_INFO("wsPath:", wsPath)
 		var boxId string
-		err := pw.redisClient.StringGet(constants.REDIS_KEY_BOXID, &boxId)
+		err = pw.redisClient.StringGet(constants.REDIS_KEY_BOXID, &boxId)
 		if err != nil {
 			logger.LOG_WARN("redis 获取boxId异常")
 			continue
 		}
 		wsPath = strings.ReplaceAll(strings.ReplaceAll(wsPath, "{namespace}", _WS_NAMESPACE), "{sid}", boxId)
 		logger.LOG_INFO("wsPath decode:", wsPath)
-		u := url.URL{Scheme: "ws", Host: centerAddr + ":" + wsPort, Path: wsPath}
-		ws, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
+		u := url.URL{Scheme: proxy.WsScheme(), Host: ep.Address(), Path: wsPath}
+		dialer := &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 45 * time.Second,
+			TLSClientConfig:  proxy.TLSConfig(),
+		}
+		header, credential, err := proxy.SignHeader(http.MethodGet, u.RequestURI(), nil)
+		if err != nil {
+			logger.LOG_WARN(err)
+			continue
+		}
+		var res *http.Response
+		ws, res, err = dialer.Dial(u.String(), header)
+		if res != nil {
+			if authErr := proxy.CheckAuth(res.StatusCode, credential); authErr != nil {
+				err = authErr
+			}
+		}
+		proxy.Centers().Report(ep.Address(), err)
 		pw.wsLock.Lock()
 		if err != nil {
 			logger.LOG_WARN(err)
 			pw.ws = nil
+			pw.lastError = err.Error()
 			pw.wsLock.Unlock()
 			continue
 		}
 		logger.LOG_INFO("websocket连接成功")
 		pw.ws = ws
+		pw.address = ep.Address()
+		pw.connectedAt = time.Now()
+		pw.received = 0
+		pw.lastError = ""
 		pw.wsLock.Unlock()
 		//读取数据
 	READ_LOOP:
@@ -138,9 +190,14 @@ LOOP:
 				logger.LOG_WARN(err)
 				pw.wsLock.Lock()
 				pw.ws = nil
+				pw.lastError = err.Error()
 				pw.wsLock.Unlock()
 				break READ_LOOP
 			}
+			pw.wsLock.Lock()
+			pw.received++
+			pw.lastMessage = time.Now()
+			pw.wsLock.Unlock()
 			select {
 			case pw.msgC <- wrap:
 			default:
@@ -151,6 +208,21 @@ LOOP:
 	}
 }
 
+//连接状态
+func (pw *PreviewWebsocket) Status() *WsStatus {
+	pw.wsLock.RLock()
+	defer pw.wsLock.RUnlock()
+	return &WsStatus{
+		Connected:   pw.ws != nil,
+		Address:     pw.address,
+		ConnectedAt: pw.connectedAt,
+		LastMessage: pw.lastMessage,
+		Received:    pw.received,
+		LastError:   pw.lastError,
+		QueueLength: len(pw.msgC),
+	}
+}
+
 func (pw *PreviewWebsocket) loopHandle() {
 	for msg := range pw.msgC {
 		logger.LOG_INFO("WS_RECEIVE_CONTENT：", string(msg.Content))
@@ -160,8 +232,12 @@ func (pw *PreviewWebsocket) loopHandle() {
 			logger.LOG_WARN(err)
 			continue
 		}
+		if cmd.Cmd == _WS_CMD_TASKS_CHANGED {
+			pw.tasksChanged(msg, cmd)
+			continue
+		}
 
-		address := getAddress(cmd.Target)
+		address := pw.e.getAddress(cmd.Target)
 		if address == "" {
 			logger.LOG_WARN("未找到目标进程，Target:", cmd.Target)
 			continue
@@ -197,6 +273,32 @@ func (pw *PreviewWebsocket) loopHandle() {
 	}
 }
 
+//任务变更通知：携带任务时直接刷新，否则立即发送心跳拉取
+func (pw *PreviewWebsocket) tasksChanged(msg *WsReceiveMessage, cmd *VideoCmd) {
+	param := &TasksChangedParam{}
+	if len(cmd.Param) > 0 {
+		err := jsoniter.Unmarshal(cmd.Param, param)
+		if err != nil {
+			logger.LOG_WARN("任务变更通知解析异常：", err)
+		}
+	}
+	if len(param.Tasks) > 0 {
+		logger.LOG_WARN("收到中心推送任务，数量：", len(param.Tasks))
+		go pw.e.td.PushTasks(param.Tasks)
+	} else {
+		logger.LOG_WARN("收到中心任务变更通知")
+		pw.e.td.TriggerHeart()
+	}
+	resBytes, _ := jsoniter.Marshal(&GalaxyResponse{
+		Code:    http.StatusOK,
+		Message: "success",
+	})
+	err := pw.asyncResponse(msg.RequestId, msg.From, msg.To, resBytes)
+	if err != nil {
+		logger.LOG_WARN("任务变更通知响应异常：", err)
+	}
+}
+
 func (pw *PreviewWebsocket) asyncResponse(requestId, to, from string, resBytes []byte) error {
 	resMsg := make(map[string]interface{})
 	err := jsoniter.Unmarshal(resBytes, &resMsg)
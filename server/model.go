@@ -1,6 +1,9 @@
 package server
 
-import jsoniter "github.com/json-iterator/go"
+import (
+	"dyzs/galaxy/model"
+	jsoniter "github.com/json-iterator/go"
+)
 
 type GalaxyCmd struct {
 	Cmd   string              `json:"cmd"`
@@ -13,27 +16,15 @@ type GalaxyResponse struct {
 	Result  interface{} `json:"Result"`
 }
 
-type Channel struct {
-	FromID       string `json:"FromId"`   // 该字段标识来源设备ID，即下级国标编号
-	DeviceID     string `json:"DeviceId"` // 该字段为通道ID
-	Name         string `json:"Name"`
-	Manufacturer string `json:"Manufacturer"`
-	Model        string `json:"Model"`
-	Owner        string `json:"Owner"`
-	CivilCode    string `json:"CivilCode"`
-	Address      string `json:"Address"`
-	Parental     int    `json:"Parental"`
-	ParentID     string `json:"ParentId"`
-	SafetyWay    int    `json:"SafetyWay"`
-	RegisterWay  int    `json:"RegisterWay"`
-	Secrecy      int    `json:"Secrecy"`
+type CatalogListParam struct {
+	SumNum      int              `json:"SumNum"` // 设备目录总数，可选，用于判断是否收到完整目录
+	CatalogList []*model.Channel `json:"CatalogList"`
+}
 
-	Status    string  `json:"Status"`
-	Longitude float64 `json:"Longitude"`
-	Latitude  float64 `json:"Latitude"`
-	PTZType   int     `json:"PTZType"`
+type DeviceIdParam struct {
+	Channel string `json:"Channel"`
 }
 
-type CatalogListParam struct {
-	CatalogList []*Channel `json:"CatalogList"`
+type ChannelStatusParam struct {
+	FromID string `json:"FromId"` // 为空时查询所有设备
 }